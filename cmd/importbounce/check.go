@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"go.alexhamlin.co/importbounce/internal/bouncer"
+)
+
+func runCheck(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s check [-config URL]\n\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Check a config file for problems without serving it.\n\n")
+		flags.PrintDefaults()
+	}
+	configURL := flags.String("config", envConfigURL, "Location of the config file to check")
+	flags.Parse(args)
+
+	problems, err := bouncer.Check(context.Background(), *configURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configURL, err)
+		os.Exit(2)
+	}
+
+	for _, problem := range problems {
+		fmt.Printf("%s: %v\n", *configURL, problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("FAIL: %d problem(s) found\n", len(problems))
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
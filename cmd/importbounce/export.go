@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"go.alexhamlin.co/importbounce/internal/bouncer"
+)
+
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export [-config URL] -out DIR\n\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Render the config as a static site, with a directory for each host.\n\n")
+		flags.PrintDefaults()
+	}
+	configURL := flags.String("config", envConfigURL, "Location of the config file to export")
+	outDir := flags.String("out", "", "Directory to write the site to")
+	flags.Parse(args)
+
+	if *outDir == "" || flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	err := bouncer.Export(context.Background(), *configURL, *outDir, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
@@ -13,11 +17,17 @@ import (
 	"go.alexhamlin.co/importbounce/internal/bouncer"
 )
 
-var envConfigURL = os.Getenv("IMPORTBOUNCE_CONFIG_URL")
+var (
+	envConfigURL                = os.Getenv("IMPORTBOUNCE_CONFIG_URL")
+	envCacheTTL, envCacheTTLErr = parseEnvDuration("IMPORTBOUNCE_CACHE_TTL")
+)
 
 var (
-	flagHTTPAddr  = flag.String("http", "", "Serve HTTP on the provided address instead of AWS Lambda")
-	flagConfigURL = flag.String("config", envConfigURL, "Location of the config file to read on each request")
+	flagHTTPAddr   = flag.String("http", "", "Serve HTTP on the provided address instead of AWS Lambda")
+	flagConfigURL  = flag.String("config", envConfigURL, "Location of the config file to serve, checked for changes as -cache-ttl or -poll allows")
+	flagCacheTTL   = flag.Duration("cache-ttl", envCacheTTL, "How long to use a loaded config before checking it for changes")
+	flagPoll       = flag.Duration("poll", 0, "With -http, reload the config in the background on this interval instead of on each request")
+	flagPollJitter = flag.Duration("poll-jitter", 0, "With -poll, add a random delay of up to this duration to each interval")
 )
 
 func init() {
@@ -25,14 +35,51 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			runCheck(os.Args[2:])
+			return
+		case "resolve":
+			runResolve(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		}
+	}
+
+	if envCacheTTLErr != nil {
+		log.Fatal(envCacheTTLErr)
+	}
 	flag.Parse()
 
 	bouncer, err := bouncer.New(*flagConfigURL)
 	if err != nil {
 		log.Fatal(err)
 	}
+	bouncer.CacheTTL = *flagCacheTTL
+
+	if *flagPoll > 0 && *flagHTTPAddr == "" {
+		log.Fatal("-poll requires -http")
+	}
 
 	if *flagHTTPAddr != "" {
+		ctx := context.Background()
+		if *flagPoll > 0 {
+			log.Printf("polling for config changes every %v (jitter %v)", *flagPoll, *flagPollJitter)
+			bouncer.Poll(ctx, *flagPoll, *flagPollJitter)
+		}
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				log.Printf("received SIGHUP, reloading config")
+				bouncer.Reload(ctx)
+			}
+		}()
+
 		log.Printf("starting HTTP server on %s", *flagHTTPAddr)
 		http.ListenAndServe(*flagHTTPAddr, bouncer)
 	} else {
@@ -40,3 +87,17 @@ func main() {
 		lambda.Start(httpadapter.NewV2(bouncer).ProxyWithContext)
 	}
 }
+
+// parseEnvDuration parses the duration in an environment variable, which is
+// zero if the variable is unset.
+func parseEnvDuration(name string) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
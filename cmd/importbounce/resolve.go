@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"go.alexhamlin.co/importbounce/internal/bouncer"
+)
+
+func runResolve(args []string) {
+	flags := flag.NewFlagSet("resolve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s resolve [-config URL] host/path...\n\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Explain how each import path would be handled.\n\n")
+		flags.PrintDefaults()
+	}
+	configURL := flags.String("config", envConfigURL, "Location of the config file to resolve against")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	err := bouncer.Resolve(context.Background(), *configURL, os.Stdout, flags.Args()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
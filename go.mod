@@ -10,6 +10,7 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.51.3
 	github.com/aws/aws-xray-sdk-go v1.8.3
 	github.com/awslabs/aws-lambda-go-api-proxy v0.16.1
+	golang.org/x/mod v0.20.0
 )
 
 require (
@@ -1,22 +1,37 @@
 package bouncer
 
 import (
-	"context"
-	"fmt"
+	"cmp"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"slices"
+	"strconv"
 	"strings"
-
-	"github.com/BurntSushi/toml"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Bouncer handles HTTP requests for Go imports by retrieving a configuration
 // file, finding a matching package prefix, and serving an appropriate
 // redirect.
 type Bouncer struct {
+	// CacheTTL is how long a loaded config is used without checking the source
+	// for changes. When it expires, the Bouncer revalidates the config with a
+	// conditional request where the source supports one, and only decodes it
+	// again if it has changed. A zero CacheTTL checks on every request.
+	CacheTTL time.Duration
+
 	fetchConfig fetcherFunc
+
+	reloadMu sync.Mutex // held while loading a new config
+	current  atomic.Pointer[loadedConfig]
+	polling  atomic.Bool
+
+	inflightMu sync.Mutex
+	inflight   *reloadCall // the reload that requests are waiting on, if any
 }
 
 // New creates a new Bouncer using the configuration from the provided URL.
@@ -37,36 +52,129 @@ func New(configURL string) (*Bouncer, error) {
 
 var allow = []string{http.MethodGet, http.MethodHead}
 
-// ServeHTTP fetches a fresh copy of the Bouncer configuration and serves the
-// appropriate redirect to an HTTP client.
+// ServeHTTP serves the appropriate redirect to an HTTP client, first loading a
+// fresh copy of the Bouncer configuration unless the Bouncer is polling for
+// changes in the background.
 func (b *Bouncer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !slices.Contains(allow, r.Method) {
+	repoPath, gitEndpoint, isGit := gitRequest(r)
+	if !isGit && !slices.Contains(allow, r.Method) {
 		w.Header().Add("Allow", strings.Join(allow, ", "))
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	config, err := b.loadConfig(r.Context())
+	config, age, err := b.currentConfig(r.Context())
 	if err != nil {
 		log.Printf("failed to load config: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if age > 0 {
+		w.Header().Set(staleConfigHeader, strconv.Itoa(int(age.Seconds())))
+	}
+
+	host, ok := config.Server.requestHost(r)
+	if !ok {
+		status := config.Server.rejectedHostStatus()
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if isGit {
+		config.serveGit(w, r, host, repoPath, gitEndpoint)
+		return
+	}
+
+	// Module paths and versions in GOPROXY requests aren't import paths.
+	if rest, ok := strings.CutPrefix(r.URL.Path, proxyPath+"/"); ok && config.Proxy != nil {
+		config.serveProxy(w, r, rest)
+		return
+	}
+
+	path, err := canonicalImportPath(host, r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The go command only ever requests canonical paths, so a request for any
+	// other form of a path comes from a web browser or some other client that
+	// can follow a redirect. A go-get request for one has no go-import tag that
+	// could match the path it asked for.
+	if urlPath := canonicalURLPath(host, path); urlPath != r.URL.Path || r.URL.RawPath != "" {
+		rs := config.responseSettings(nil, nil)
+		if r.URL.Query().Get("go-get") != "" {
+			rs.serveError(w, "Not a canonical import path\n", http.StatusNotFound)
+			return
+		}
+		target := (&url.URL{Path: urlPath, RawQuery: r.URL.RawQuery}).String()
+		rs.serveRedirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
 
-	path := r.Host + r.URL.Path
-	pkgConf := config.FindPackage(path)
+	hostConf := config.lookupHost(host)
+	if hostConf == nil {
+		unknown := cmp.Or(config.UnknownHost, &unknownHostConfig{})
+		b.tryDefaultRedirect(w, r, path, unknown.Redirect, cmp.Or(unknown.NotFound, "Unknown host\n"),
+			config.responseSettings(nil, nil))
+		return
+	}
+
+	if r.URL.Path == catalogPath {
+		rs := config.responseSettings(hostConf, nil)
+		if err := rs.serveJSON(w, r, rs.cache.GoGet, hostConf.catalog(host)); err != nil {
+			log.Printf("failed to write package catalog for %s: %v", host, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
 
-	if pkgConf == (packageConfig{}) {
-		b.tryDefaultRedirect(w, r, config.DefaultRedirect)
+	pkgConf := hostConf.findPackage(path)
+	if pkgConf.Prefix == "" && path == host && hostConf.IndexPage && r.URL.Query().Get("go-get") == "" {
+		rs := config.responseSettings(hostConf, nil)
+		if err := rs.servePage(w, r, rs.cache.Redirect, config.indexTemplate(hostConf), hostConf.indexPage(host)); err != nil {
+			log.Printf("failed to render index page for %s: %v", host, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if pkgConf.Prefix == "" {
+		b.tryDefaultRedirect(w, r, hostConf.canonicalPath(path),
+			cmp.Or(hostConf.DefaultRedirect, config.DefaultRedirect),
+			cmp.Or(hostConf.NotFound, "Package not found\n"),
+			config.responseSettings(hostConf, nil))
 		return
 	}
 
+	rs := config.responseSettings(hostConf, &pkgConf)
 	if r.URL.Query().Get("go-get") == "" {
-		http.Redirect(w, r, pkgConf.Redirect, http.StatusFound)
+		// Clients other than the go command can ask for the package's catalog
+		// entry instead of a response meant for web visitors.
+		w.Header().Add("Vary", "Accept")
+		if prefersJSON(r) {
+			if err := rs.serveJSON(w, r, rs.cache.GoGet, pkgConf.catalogEntry()); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	if pkgConf.Status == statusRetired {
+		rs.serveError(w, pkgConf.Notice()+"\n", http.StatusGone)
 		return
 	}
 
-	err = responseTmpl.Execute(w, pkgConf)
+	pkgConf.Redirect = expandPlaceholders(pkgConf.Redirect, map[string]string{queryVar: browserQuery(r)})
+	if r.URL.Query().Get("go-get") == "" {
+		switch pkgConf.Status {
+		case statusMoved:
+			rs.serveRedirect(w, r, pkgConf.successorURL(path, browserQuery(r)), http.StatusMovedPermanently)
+		case statusDeprecated:
+			err = rs.servePage(w, r, rs.cache.Redirect, noticeTmpl, pkgConf)
+		default:
+			rs.serveRedirect(w, r, pkgConf.Redirect, 0)
+		}
+	} else {
+		err = rs.servePage(w, r, rs.cache.GoGet, responseTmpl, pkgConf)
+	}
 	if err != nil {
 		// This is going to be best-effort.
 		w.WriteHeader(http.StatusInternalServerError)
@@ -75,33 +183,39 @@ func (b *Bouncer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 var responseTmpl = template.Must(template.New("").Parse(`<html>
 <head>
-<meta name="go-import" content="{{.Prefix}} {{.Import}}">
+{{template "go-import" .}}
+{{- with .GoSource}}
+<meta name="go-source" content="{{$.Prefix}} {{.Home}} {{.Directory}} {{.File}}">
+{{- end}}
 <meta http-equiv="refresh" content="0; url={{.Redirect}}">
 </head>
 <body>Redirecting…</body>
-</html>`))
-
-func (b *Bouncer) loadConfig(ctx context.Context) (config, error) {
-	r, err := b.fetchConfig(ctx)
-	if err != nil {
-		return config{}, fmt.Errorf("fetching config: %w", err)
-	}
-	defer r.Close()
-
-	var c config
-	_, err = toml.NewDecoder(r).Decode(&c)
-	if err != nil {
-		return config{}, fmt.Errorf("decoding config: %w", err)
-	}
-	return c, err
-}
-
-func (b *Bouncer) tryDefaultRedirect(w http.ResponseWriter, r *http.Request, url string) {
+</html>
+{{- define "go-import"}}
+{{- range $i, $content := .GoImports}}{{if $i}}
+{{end}}<meta name="go-import" content="{{$content}}">{{end}}
+{{- end}}`))
+
+// staleConfigHeader is set on responses served from a last-known-good config,
+// and holds the number of seconds since that config was known to be current.
+const staleConfigHeader = "X-Importbounce-Stale-Config-Age"
+
+// tryDefaultRedirect redirects a web browser to url, with placeholders for
+// the requested path filled in, or serves a 404 response with the notFound
+// body to the go command or if url is empty.
+func (b *Bouncer) tryDefaultRedirect(w http.ResponseWriter, r *http.Request, path, url, notFound string, rs responseSettings) {
 	if url == "" || r.URL.Query().Get("go-get") != "" {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("Package not found\n"))
+		rs.serveError(w, notFound, http.StatusNotFound)
 		return
 	}
 
-	http.Redirect(w, r, url, http.StatusFound)
+	path = strings.TrimSuffix(path, "/")
+	host, rest, _ := strings.Cut(path, "/")
+	url = expandPlaceholders(url, map[string]string{
+		pathVar:  path,
+		hostVar:  host,
+		restVar:  rest,
+		queryVar: browserQuery(r),
+	})
+	rs.serveRedirect(w, r, url, 0)
 }
@@ -0,0 +1,1471 @@
+package bouncer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+[[packages]]
+prefix = "go.alexhamlin.co/importbounce"
+import = "git https://github.com/ahamlinman/importbounce"
+redirect = "https://github.com/ahamlinman/importbounce"
+`
+
+func TestLastGoodConfigFallback(t *testing.T) {
+	var (
+		body string
+		err  error
+	)
+	b := &Bouncer{
+		fetchConfig: func(_ context.Context, _ configVersion) (io.ReadCloser, configVersion, error) {
+			if err != nil {
+				return nil, configVersion{}, err
+			}
+			return io.NopCloser(strings.NewReader(body)), configVersion{}, nil
+		},
+	}
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "https://go.alexhamlin.co/importbounce", nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		return w
+	}
+
+	err = errors.New("config unavailable")
+	if w := serve(); w.Code != http.StatusInternalServerError {
+		t.Fatalf("with no good config: got status %d; want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	body, err = testConfig, nil
+	if w := serve(); w.Code != http.StatusFound || w.Header().Get(staleConfigHeader) != "" {
+		t.Fatalf("with good config: got status %d, stale header %q", w.Code, w.Header().Get(staleConfigHeader))
+	}
+
+	for _, bad := range []struct {
+		body string
+		err  error
+	}{
+		{err: errors.New("config unavailable")},
+		{body: "[[packages]\nprefix = "},
+	} {
+		// A failed load isn't retried for a while, so start each case from a
+		// good config.
+		body, err = testConfig, nil
+		if _, err := b.Reload(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		body, err = bad.body, bad.err
+		w := serve()
+		if w.Code != http.StatusFound {
+			t.Errorf("with bad config: got status %d; want %d", w.Code, http.StatusFound)
+		}
+		if w.Header().Get(staleConfigHeader) == "" {
+			t.Errorf("with bad config: missing %s header", staleConfigHeader)
+		}
+	}
+}
+
+func TestFailedLoadRetry(t *testing.T) {
+	var (
+		fetches int
+		err     error
+	)
+	b := &Bouncer{
+		fetchConfig: func(_ context.Context, _ configVersion) (io.ReadCloser, configVersion, error) {
+			fetches++
+			if err != nil {
+				return nil, configVersion{}, err
+			}
+			return io.NopCloser(strings.NewReader(testConfig)), configVersion{}, nil
+		},
+	}
+
+	serve := func() {
+		req := httptest.NewRequest(http.MethodGet, "https://go.alexhamlin.co/importbounce", nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Fatalf("got status %d; want %d", w.Code, http.StatusFound)
+		}
+	}
+
+	serve()
+	err = errors.New("config unavailable")
+	for range 5 {
+		serve()
+	}
+	if fetches != 2 {
+		t.Errorf("while the source is failing: got %d fetches; want 2", fetches)
+	}
+
+	// Pretend that the retry interval has passed.
+	stale := *b.current.Load()
+	stale.failedAt = stale.failedAt.Add(-minRetryInterval)
+	b.current.Store(&stale)
+	err = nil
+	serve()
+	serve()
+	if fetches != 4 {
+		t.Errorf("after the retry interval: got %d fetches; want 4", fetches)
+	}
+	if b.current.Load().stale {
+		t.Error("config is still stale after a successful retry")
+	}
+}
+
+func TestConfigCache(t *testing.T) {
+	var (
+		fetches  int
+		decodes  int
+		etag     = `"1"`
+		lastPrev configVersion
+	)
+	b := &Bouncer{
+		fetchConfig: func(_ context.Context, prev configVersion) (io.ReadCloser, configVersion, error) {
+			fetches++
+			lastPrev = prev
+			if prev.ETag == etag {
+				return nil, configVersion{}, errNotModified
+			}
+			decodes++
+			return io.NopCloser(strings.NewReader(testConfig)), configVersion{ETag: etag}, nil
+		},
+	}
+
+	serve := func() {
+		req := httptest.NewRequest(http.MethodGet, "https://go.alexhamlin.co/importbounce", nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Fatalf("got status %d; want %d", w.Code, http.StatusFound)
+		}
+	}
+
+	b.CacheTTL = time.Hour
+	serve()
+	serve()
+	if fetches != 1 {
+		t.Errorf("within TTL: got %d fetches; want 1", fetches)
+	}
+
+	b.CacheTTL = 0
+	serve()
+	if fetches != 2 || decodes != 1 {
+		t.Errorf("after TTL with unchanged config: got %d fetches and %d decodes; want 2 and 1", fetches, decodes)
+	}
+	if lastPrev.ETag != etag {
+		t.Errorf("revalidated with ETag %q; want %q", lastPrev.ETag, etag)
+	}
+
+	etag = `"2"`
+	serve()
+	if decodes != 2 {
+		t.Errorf("after TTL with changed config: got %d decodes; want 2", decodes)
+	}
+}
+
+func TestConcurrentReloads(t *testing.T) {
+	var (
+		fetches atomic.Int32
+		start   sync.Once
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	b := &Bouncer{
+		fetchConfig: func(_ context.Context, _ configVersion) (io.ReadCloser, configVersion, error) {
+			fetches.Add(1)
+			start.Do(func() { close(started) })
+			<-release
+			return io.NopCloser(strings.NewReader(testConfig)), configVersion{}, nil
+		},
+	}
+
+	const requests = 10
+	var wg sync.WaitGroup
+	codes := make([]int, requests)
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "https://go.alexhamlin.co/importbounce", nil)
+			w := httptest.NewRecorder()
+			b.ServeHTTP(w, req)
+			codes[i] = w.Code
+		}()
+	}
+
+	// Give every request a chance to start waiting on the first fetch, even
+	// though a zero CacheTTL means that any request arriving after it ends
+	// would need another one.
+	<-started
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("got %d fetches for %d concurrent requests; want 1", n, requests)
+	}
+	for i, code := range codes {
+		if code != http.StatusFound {
+			t.Errorf("request %d: got status %d; want %d", i, code, http.StatusFound)
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	var fetches atomic.Int32
+	b := &Bouncer{
+		fetchConfig: func(_ context.Context, _ configVersion) (io.ReadCloser, configVersion, error) {
+			fetches.Add(1)
+			return io.NopCloser(strings.NewReader(testConfig)), configVersion{}, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.Poll(ctx, time.Hour, 0)
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("after starting to poll: got %d fetches; want 1", n)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "https://go.alexhamlin.co/importbounce", nil)
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusFound)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("after serving while polling: got %d fetches; want 1", n)
+	}
+}
+
+func TestServeGoImport(t *testing.T) {
+	testCases := []struct {
+		description string
+		toml        string
+		path        string
+		want        string
+	}{
+		{
+			description: "basic",
+			toml:        testConfig,
+			path:        "go.alexhamlin.co/importbounce/internal/bouncer",
+			want: `<meta name="go-import" content="go.alexhamlin.co/importbounce git https://github.com/ahamlinman/importbounce">
+<meta name="go-source" content="go.alexhamlin.co/importbounce https://github.com/ahamlinman/importbounce https://github.com/ahamlinman/importbounce/tree/HEAD{/dir} https://github.com/ahamlinman/importbounce/blob/HEAD{/dir}/{file}#L{line}">`,
+		},
+		{
+			description: "subdir",
+			toml: `
+				[[packages]]
+				prefix = "example.com/tool"
+				import = "git https://github.com/acme/monorepo"
+				subdir = "tools/cli"
+				redirect = "https://pkg.go.dev/example.com/tool"
+			`,
+			path: "example.com/tool",
+			want: `<meta name="go-import" content="example.com/tool git https://github.com/acme/monorepo tools/cli">
+<meta name="go-source" content="example.com/tool https://github.com/acme/monorepo https://github.com/acme/monorepo/tree/HEAD/tools/cli{/dir} https://github.com/acme/monorepo/blob/HEAD/tools/cli{/dir}/{file}#L{line}">`,
+		},
+		{
+			description: "multiple imports",
+			toml: `
+				[[packages]]
+				prefix = "example.com/tool"
+				import = ["git https://github.com/acme/monorepo", "mod https://goproxy.example.com"]
+				subdir = "tools/cli"
+				redirect = "https://pkg.go.dev/example.com/tool"
+			`,
+			path: "example.com/tool/sub",
+			want: `<meta name="go-import" content="example.com/tool mod https://goproxy.example.com">
+<meta name="go-import" content="example.com/tool git https://github.com/acme/monorepo tools/cli">
+<meta name="go-source" content="example.com/tool https://github.com/acme/monorepo https://github.com/acme/monorepo/tree/HEAD/tools/cli{/dir} https://github.com/acme/monorepo/blob/HEAD/tools/cli{/dir}/{file}#L{line}">`,
+		},
+		{
+			description: "forge shorthand",
+			toml: `
+				[[packages]]
+				prefix = "example.com/{repo}"
+				gitlab = "acme/go/{repo}"
+			`,
+			path: "example.com/widget/sub",
+			want: `<meta name="go-import" content="example.com/widget git https://gitlab.com/acme/go/widget">
+<meta name="go-source" content="example.com/widget https://gitlab.com/acme/go/widget https://gitlab.com/acme/go/widget/-/tree/HEAD{/dir} https://gitlab.com/acme/go/widget/-/blob/HEAD{/dir}/{file}#L{line}">`,
+		},
+		{
+			description: "unknown forge",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://git.example.com/a.git"
+				redirect = "https://pkg.go.dev/example.com/a"
+			`,
+			path: "example.com/a",
+			want: `<meta name="go-import" content="example.com/a git https://git.example.com/a.git">`,
+		},
+		{
+			description: "self-hosted forge",
+			toml: `
+				[[packages]]
+				prefix = "example.com/{repo}"
+				import = "git https://git.example.com/acme/{repo}.git"
+				redirect = "https://pkg.go.dev/example.com/{repo}"
+				source = { forge = "gitea", branch = "trunk" }
+			`,
+			path: "example.com/a",
+			want: `<meta name="go-import" content="example.com/a git https://git.example.com/acme/a.git">
+<meta name="go-source" content="example.com/a https://git.example.com/acme/a https://git.example.com/acme/a/src/branch/trunk{/dir} https://git.example.com/acme/a/src/branch/trunk{/dir}/{file}#L{line}">`,
+		},
+		{
+			description: "explicit source",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://github.com/acme/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+				source = { file = "https://cs.example.com/a/{dir}/{file}?l={line}" }
+			`,
+			path: "example.com/a",
+			want: `<meta name="go-import" content="example.com/a git https://github.com/acme/a">
+<meta name="go-source" content="example.com/a https://github.com/acme/a https://github.com/acme/a/tree/HEAD{/dir} https://cs.example.com/a/{dir}/{file}?l={line}">`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			b := testBouncer(tc.toml)
+
+			req := httptest.NewRequest(http.MethodGet, "https://"+tc.path+"?go-get=1", nil)
+			w := httptest.NewRecorder()
+			b.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d; want %d", w.Code, http.StatusOK)
+			}
+
+			var got []string
+			for _, line := range strings.Split(w.Body.String(), "\n") {
+				if strings.HasPrefix(line, `<meta name="go-`) {
+					got = append(got, line)
+				}
+			}
+			if strings.Join(got, "\n") != tc.want {
+				t.Errorf("wrong meta tags\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), tc.want)
+			}
+		})
+	}
+}
+
+func TestBrowserRedirect(t *testing.T) {
+	b := testBouncer(`
+		default_redirect = "https://{host}/search{?query}#{rest}"
+
+		[[packages]]
+		prefix = "example.com/a"
+		github = "acme/a"
+
+		[[packages]]
+		prefix = "example.com/b"
+		codeberg = "acme/b"
+		redirect = "https://example.com/b"
+
+		[[packages]]
+		prefix = "example.com/{repo}"
+		github = "acme/{repo}"
+		redirect = "https://github.com/acme/{repo}/tree/main/{rest}{?query}"
+	`)
+
+	for target, want := range map[string]string{
+		"https://example.com/a/sub/pkg":            "https://pkg.go.dev/example.com/a/sub/pkg",
+		"https://example.com/b/sub/pkg":            "https://example.com/b",
+		"https://example.com/c/sub/pkg?tab=readme": "https://github.com/acme/c/tree/main/sub/pkg?tab=readme",
+		"https://example.com/c":                    "https://github.com/acme/c/tree/main/",
+		"https://example.com/c/":                   "/c",
+		"https://other.example.com/x/y?tab=readme": "https://other.example.com/search?tab=readme#x/y",
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if got := w.Header().Get("Location"); got != want {
+			t.Errorf("%s: redirected to %q; want %q", target, got, want)
+		}
+	}
+}
+
+func TestServeHosts(t *testing.T) {
+	b := testBouncer(`
+		default_redirect = "https://example.com/"
+
+		[[packages]]
+		prefix = "legacy.example.com/a"
+		github = "acme/a"
+
+		[[packages]]
+		prefix = "Upper.example.com/Up"
+		github = "acme/up"
+
+		[hosts."go.example.com"]
+		aliases = ["www.go.example.com"]
+		not_found = "No such package.\n"
+
+		[[hosts."go.example.com".packages]]
+		prefix = "b"
+		github = "acme/b"
+
+		[hosts."go.example.org"]
+		default_redirect = "https://example.org{/rest}"
+
+		[unknown_host]
+		not_found = "No such host.\n"
+	`)
+
+	testCases := []struct {
+		target string
+		status int
+		want   string // the Location header or body
+	}{
+		{"https://go.example.com/b/sub?go-get=1", http.StatusOK, `content="go.example.com/b git https://github.com/acme/b"`},
+		{"https://GO.Example.com:8443/b?go-get=1", http.StatusOK, `content="go.example.com/b git https://github.com/acme/b"`},
+		{"https://www.go.example.com/b?go-get=1", http.StatusOK, `content="www.go.example.com/b git https://github.com/acme/b"`},
+		{"https://www.go.example.com/b/sub", http.StatusFound, "https://pkg.go.dev/go.example.com/b/sub"},
+		{"https://go.example.com/c?go-get=1", http.StatusNotFound, "No such package.\n"},
+		{"https://go.example.com/c", http.StatusFound, "https://example.com/"},
+		{"https://go.example.org/c/d", http.StatusFound, "https://example.org/c/d"},
+		{"https://go.example.org/c?go-get=1", http.StatusNotFound, "Package not found\n"},
+		{"https://legacy.example.com/a?go-get=1", http.StatusOK, `content="legacy.example.com/a git https://github.com/acme/a"`},
+		{"https://legacy.example.com/b", http.StatusFound, "https://example.com/"},
+		{"https://upper.example.com/Up?go-get=1", http.StatusOK, `content="upper.example.com/Up git https://github.com/acme/up"`},
+		{"https://other.example.com/b", http.StatusNotFound, "No such host.\n"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: got status %d; want %d", tc.target, w.Code, tc.status)
+			continue
+		}
+		got := w.Body.String()
+		if w.Code == http.StatusFound {
+			got = w.Header().Get("Location")
+		}
+		if got != tc.want && (w.Code != http.StatusOK || !strings.Contains(got, tc.want)) {
+			t.Errorf("%s: got %q; want %q", tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestServeAllowedHosts(t *testing.T) {
+	b := testBouncer(`
+		[server]
+		allowed_hosts = ["go.example.com", "go.example.org"]
+
+		[[server.trusted_proxies]]
+		from = ["10.0.0.0/8"]
+		header = "X-Forwarded-Host"
+
+		[[server.trusted_proxies]]
+		from = ["192.0.2.1", "2001:db8::/32"]
+		header = "Forwarded"
+
+		[[packages]]
+		prefix = "go.example.com/a"
+		github = "acme/a"
+
+		[[packages]]
+		prefix = "go.example.org/a"
+		github = "acme/a"
+	`)
+
+	testCases := []struct {
+		description string
+		host        string
+		remoteAddr  string
+		header      http.Header
+		status      int
+		want        string // the Location header, if the status is 302
+	}{
+		{
+			description: "allowed host",
+			host:        "Go.Example.com:443",
+			remoteAddr:  "198.51.100.1:1234",
+			status:      http.StatusFound,
+			want:        "https://pkg.go.dev/go.example.com/a",
+		},
+		{
+			description: "disallowed host",
+			host:        "scanner.example.net",
+			remoteAddr:  "198.51.100.1:1234",
+			status:      http.StatusMisdirectedRequest,
+		},
+		{
+			description: "untrusted forwarded host",
+			host:        "go.example.com",
+			remoteAddr:  "198.51.100.1:1234",
+			header:      http.Header{"X-Forwarded-Host": {"go.example.org"}},
+			status:      http.StatusFound,
+			want:        "https://pkg.go.dev/go.example.com/a",
+		},
+		{
+			description: "trusted X-Forwarded-Host",
+			host:        "internal.example.net",
+			remoteAddr:  "10.1.2.3:1234",
+			header:      http.Header{"X-Forwarded-Host": {"scanner.example.net, go.example.org"}},
+			status:      http.StatusFound,
+			want:        "https://pkg.go.dev/go.example.org/a",
+		},
+		{
+			description: "trusted Forwarded",
+			host:        "internal.example.net",
+			remoteAddr:  "192.0.2.1",
+			header:      http.Header{"Forwarded": {`for=198.51.100.1;host=scanner.example.net`, `for=198.51.100.1;proto=https;host="go.example.org"`}},
+			status:      http.StatusFound,
+			want:        "https://pkg.go.dev/go.example.org/a",
+		},
+		{
+			description: "wrong header from trusted proxy",
+			host:        "internal.example.net",
+			remoteAddr:  "[2001:db8::1]:1234",
+			header:      http.Header{"X-Forwarded-Host": {"go.example.org"}},
+			status:      http.StatusMisdirectedRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/a", nil)
+			req.Host = tc.host
+			req.RemoteAddr = tc.remoteAddr
+			for key, values := range tc.header {
+				req.Header[key] = values
+			}
+
+			w := httptest.NewRecorder()
+			b.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Fatalf("got status %d; want %d", w.Code, tc.status)
+			}
+			if got := w.Header().Get("Location"); got != tc.want {
+				t.Errorf("redirected to %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServeCanonicalPath(t *testing.T) {
+	b := testBouncer(`
+		[[packages]]
+		prefix = "example.com/a"
+		github = "acme/a"
+	`)
+
+	testCases := []struct {
+		target string
+		status int
+		want   string // the Location header or go-import tag
+	}{
+		{"https://example.com/a/b", http.StatusFound, "https://pkg.go.dev/example.com/a/b"},
+		{"https://example.com//a/./c/../b/", http.StatusMovedPermanently, "/a/b"},
+		{"https://example.com/a.git?tab=readme", http.StatusMovedPermanently, "/a?tab=readme"},
+		{"https://example.com/%61/b", http.StatusMovedPermanently, "/a/b"},
+		{"https://example.com/a/b?go-get=1", http.StatusOK, `<meta name="go-import" content="example.com/a git https://github.com/acme/a">`},
+		{"https://example.com/a/b/?go-get=1", http.StatusNotFound, ""},
+		{"https://example.com/a.git?go-get=1", http.StatusNotFound, ""},
+		{"https://EXAMPLE.com/a?go-get=1", http.StatusOK, `<meta name="go-import" content="example.com/a git https://github.com/acme/a">`},
+		{"https://example.com/a/b%22c", http.StatusBadRequest, ""},
+		{"https://example.com/a/b%20c?go-get=1", http.StatusBadRequest, ""},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: got status %d; want %d", tc.target, w.Code, tc.status)
+			continue
+		}
+		got := w.Header().Get("Location")
+		if w.Code == http.StatusOK {
+			got, _, _ = strings.Cut(w.Body.String()[strings.Index(w.Body.String(), "<meta"):], "\n")
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q; want %q", tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestServePackageStatus(t *testing.T) {
+	b := testBouncer(`
+		[[packages]]
+		prefix = "example.com/old"
+		github = "acme/old"
+		status = "deprecated"
+		successor = "example.com/new"
+		versions.v2 = { subdir = "v2" }
+
+		[[packages]]
+		prefix = "example.com/lib"
+		github = "acme/lib"
+		status = "moved"
+		successor = "example.org/lib"
+		versions.v2 = { subdir = "v2" }
+
+		[[packages]]
+		prefix = "example.com/{repo}/legacy"
+		github = "acme/{repo}"
+		status = "moved"
+		successor = "example.com/{repo}/v2"
+
+		[[packages]]
+		prefix = "example.com/gone"
+		status = "retired"
+		message = "This package was an experiment, and is no longer available."
+	`)
+
+	testCases := []struct {
+		target string
+		status int
+		want   string // the Location header, or a substring of the body
+	}{
+		{"https://example.com/old?go-get=1", http.StatusOK, `<meta name="go-import" content="example.com/old git https://github.com/acme/old">`},
+		{"https://example.com/old/sub", http.StatusOK, "<p>example.com/old is deprecated. Use example.com/new instead.</p>"},
+		{"https://example.com/old/v2/sub", http.StatusOK, "<p>example.com/old/v2 is deprecated. Use example.com/new/v2 instead.</p>"},
+		{"https://example.com/lib/sub", http.StatusMovedPermanently, "https://example.org/lib/sub"},
+		{"https://example.com/lib/v2/sub", http.StatusMovedPermanently, "https://example.org/lib/v2/sub"},
+		{"https://example.com" + catalogPath, http.StatusOK, `"successor": "example.org/lib/v2"`},
+		{"https://example.com/widget/legacy/sub?go-get=1", http.StatusOK, `<meta name="go-import" content="example.com/widget/legacy git https://github.com/acme/widget">`},
+		{"https://example.com/widget/legacy/sub?tab=doc", http.StatusMovedPermanently, "https://example.com/widget/v2/sub?tab=doc"},
+		{"https://example.com/gone/sub?go-get=1", http.StatusGone, "This package was an experiment, and is no longer available.\n"},
+		{"https://example.com/gone", http.StatusGone, "This package was an experiment, and is no longer available.\n"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: got status %d; want %d", tc.target, w.Code, tc.status)
+			continue
+		}
+		if location := w.Header().Get("Location"); location != "" {
+			if location != tc.want {
+				t.Errorf("%s: redirected to %q; want %q", tc.target, location, tc.want)
+			}
+		} else if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: body does not contain %q:\n%s", tc.target, tc.want, w.Body.String())
+		}
+	}
+}
+
+func TestServeCacheHeaders(t *testing.T) {
+	const configTOML = `
+		redirect_status = 307
+
+		[cache]
+		go_get = { max_age = "1h", stale_while_revalidate = "24h" }
+		redirect = { max_age = "5m" }
+		not_found = { cache_control = "no-store" }
+
+		[[packages]]
+		prefix = "example.com/a"
+		github = "acme/a"
+
+		[[packages]]
+		prefix = "example.com/b"
+		github = "acme/b"
+		redirect_status = 308
+		cache.redirect = { max_age = "24h" }
+
+		[hosts."go.example.com"]
+		redirect_status = 301
+		cache.go_get = { cache_control = "private, max-age=60" }
+
+		[[hosts."go.example.com".packages]]
+		prefix = "c"
+		github = "acme/c"
+	`
+	b := testBouncer(configTOML)
+
+	testCases := []struct {
+		target       string
+		status       int
+		cacheControl string
+	}{
+		{"https://example.com/a?go-get=1", http.StatusOK, "public, max-age=3600, stale-while-revalidate=86400"},
+		{"https://example.com/a", http.StatusTemporaryRedirect, "public, max-age=300"},
+		{"https://example.com/b", http.StatusPermanentRedirect, "public, max-age=86400"},
+		{"https://example.com/z?go-get=1", http.StatusNotFound, "no-store"},
+		{"https://go.example.com/c?go-get=1", http.StatusOK, "private, max-age=60"},
+		{"https://go.example.com/c", http.StatusMovedPermanently, "public, max-age=300"},
+	}
+	var etag string
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: got status %d; want %d", tc.target, w.Code, tc.status)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tc.cacheControl {
+			t.Errorf("%s: got Cache-Control %q; want %q", tc.target, got, tc.cacheControl)
+		}
+		if got := w.Header().Get("ETag"); got == "" || (etag != "" && got != etag) {
+			t.Errorf("%s: got ETag %q; want %q for every response", tc.target, got, etag)
+		} else {
+			etag = got
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/a?go-get=1", nil)
+	req.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() > 0 {
+		t.Errorf("revalidation got status %d with %d byte body; want %d with none", w.Code, w.Body.Len(), http.StatusNotModified)
+	}
+
+	b.fetchConfig = testBouncer(configTOML + "\n# A change to the config.\n").fetchConfig
+	w = httptest.NewRecorder()
+	b.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("revalidation after config change got status %d with ETag %q; want %d with a new ETag", w.Code, w.Header().Get("ETag"), http.StatusOK)
+	}
+}
+
+func TestServeIndexPage(t *testing.T) {
+	b := testBouncer(`
+		index_page = true
+
+		[[packages]]
+		prefix = "example.com/b"
+		github = "acme/b"
+		description = "The B library."
+
+		[[packages]]
+		prefix = "example.com/a"
+		import = "mod https://proxy.example.com"
+		redirect = "https://docs.example.com/a"
+		status = "deprecated"
+
+		[[packages]]
+		prefix = "example.net/x"
+		github = "acme/x"
+
+		[hosts."go.example.com"]
+		aliases = ["www.go.example.com"]
+		index_page = true
+		index_page_template = """
+		{{- .Host}}:{{range .Packages}} {{.ImportPath}} ({{.Repo}}){{end}}
+		"""
+
+		[[hosts."go.example.com".packages]]
+		prefix = "{repo}"
+		github = "acme/{repo}"
+
+		[hosts."go.example.org"]
+		default_redirect = "https://example.org"
+	`)
+
+	testCases := []struct {
+		target string
+		status int
+		want   []string // substrings of the body, or the Location header
+	}{
+		{
+			target: "https://example.com/",
+			status: http.StatusOK,
+			want: []string{
+				`<a href="https://docs.example.com/a">example.com/a</a> (deprecated)</td>
+<td>https://proxy.example.com</td>`,
+				`<a href="https://pkg.go.dev/example.com/b">example.com/b</a></td>
+<td>https://github.com/acme/b</td>
+<td>The B library.</td>`,
+			},
+		},
+		{
+			target: "https://www.go.example.com/",
+			status: http.StatusOK,
+			want:   []string{"go.example.com: go.example.com/{repo} (https://github.com/acme/{repo})\n"},
+		},
+		{
+			target: "https://example.com/?go-get=1",
+			status: http.StatusNotFound,
+		},
+		{
+			target: "https://go.example.org/",
+			status: http.StatusFound,
+			want:   []string{"https://example.org"},
+		},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: got status %d; want %d", tc.target, w.Code, tc.status)
+			continue
+		}
+		got := w.Body.String()
+		if location := w.Header().Get("Location"); location != "" {
+			got = location
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: response does not contain %q:\n%s", tc.target, want, got)
+			}
+		}
+		if strings.Contains(got, "example.net") {
+			t.Errorf("%s: response lists packages for another host:\n%s", tc.target, got)
+		}
+	}
+}
+
+func TestServeCatalog(t *testing.T) {
+	b := testBouncer(`
+		[[packages]]
+		prefix = "example.com/lib"
+		import = ["mod https://proxy.example.com", "git https://git.example.com/lib"]
+		redirect = "https://docs.example.com/lib{?query}"
+		description = "A library."
+		versions.v2 = { import = "git https://git.example.com/lib2", redirect = "https://docs.example.com/lib/{major}" }
+
+		[[packages]]
+		prefix = "example.com/old"
+		status = "retired"
+		successor = "example.com/lib"
+
+		[[packages]]
+		prefix = "example.net/x"
+		github = "acme/x"
+
+		[hosts."go.example.com"]
+		aliases = ["www.go.example.com"]
+
+		[[hosts."go.example.com".packages]]
+		prefix = "{repo}"
+		github = "acme/{repo}"
+		status = "deprecated"
+	`)
+
+	testCases := []struct {
+		target string
+		accept string
+		want   any
+	}{
+		{
+			target: "https://example.com/.well-known/importbounce/packages.json",
+			want: &catalog{
+				Host: "example.com",
+				Packages: []catalogEntry{
+					{
+						Prefix:      "example.com/lib",
+						VCS:         "git",
+						RepoRoot:    "https://git.example.com/lib",
+						ModuleProxy: "https://proxy.example.com",
+						Redirect:    "https://docs.example.com/lib",
+						Status:      "active",
+						Description: "A library.",
+					},
+					{
+						Prefix:   "example.com/lib/v2",
+						VCS:      "git",
+						RepoRoot: "https://git.example.com/lib2",
+						Redirect: "https://docs.example.com/lib/v2",
+						Status:   "active",
+						// Settings that the version doesn't override are the
+						// same as for the package.
+						Description: "A library.",
+					},
+					{
+						Prefix:    "example.com/old",
+						Status:    "retired",
+						Successor: "example.com/lib",
+					},
+				},
+			},
+		},
+		{
+			target: "https://www.go.example.com/.well-known/importbounce/packages.json",
+			want: &catalog{
+				Host: "go.example.com",
+				Packages: []catalogEntry{{
+					Prefix:   "go.example.com/{repo}",
+					VCS:      "git",
+					RepoRoot: "https://github.com/acme/{repo}",
+					Redirect: "https://pkg.go.dev/{path}",
+					Status:   "deprecated",
+				}},
+			},
+		},
+		{
+			target: "https://example.com/lib/v2/sub?tab=doc",
+			accept: "application/json",
+			want: &catalogEntry{
+				Prefix:      "example.com/lib/v2",
+				VCS:         "git",
+				RepoRoot:    "https://git.example.com/lib2",
+				Redirect:    "https://docs.example.com/lib/v2",
+				Status:      "active",
+				Description: "A library.",
+			},
+		},
+		{
+			target: "https://go.example.com/tool",
+			accept: "text/html;q=0.9, application/json",
+			want: &catalogEntry{
+				Prefix:   "go.example.com/tool",
+				VCS:      "git",
+				RepoRoot: "https://github.com/acme/tool",
+				Redirect: "https://pkg.go.dev/go.example.com/tool",
+				Status:   "deprecated",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d; want %d", tc.target, w.Code, http.StatusOK)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s: got Content-Type %q; want application/json", tc.target, got)
+		}
+		got := reflect.New(reflect.TypeOf(tc.want).Elem()).Interface()
+		if err := json.Unmarshal(w.Body.Bytes(), got); err != nil {
+			t.Errorf("%s: invalid JSON: %v", tc.target, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v; want %+v", tc.target, got, tc.want)
+		}
+	}
+
+	// The JSON and the redirect for a package path are different
+	// representations, which caches must keep apart.
+	etags := make(map[string]string)
+	for _, accept := range []string{"application/json", "text/html"} {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/lib", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if got := w.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("%s response: got Vary %q; want Accept", accept, got)
+		}
+		etags[accept] = w.Header().Get("ETag")
+	}
+	if etags["application/json"] == etags["text/html"] {
+		t.Errorf("JSON and redirect responses share ETag %q", etags["text/html"])
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	testCases := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"application/json, text/plain;q=0.5", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"text/html;q=0.5, application/*", true},
+		{"application/json;q=0.5, */*", false},
+		{"application/json;q=0, */*", false},
+		{"application/json;q=bogus", false},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		req.Header.Set("Accept", tc.accept)
+		if got := prefersJSON(req); got != tc.want {
+			t.Errorf("prefersJSON(%q) = %v; want %v", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestServeProxy(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"example.com/mod/@v/list":                "v1.0.0\nv1.1.0\nv1.2.0-pre\n",
+		"example.com/mod/@v/v1.1.0.info":         `{"Version":"v1.1.0"}`,
+		"example.com/mod/@v/v1.1.0.mod":          "module example.com/mod\n",
+		"example.com/mod/@v/v1.1.0.zip":          "PK",
+		"example.com/mod/v2/@v/list":             "v2.0.0-beta\n",
+		"example.com/mod/v2/@v/v2.0.0-beta.info": `{"Version":"v2.0.0-beta"}`,
+		"example.com/!upper/@latest":             `{"Version":"v0.1.0"}`,
+		"example.com/git/@v/list":                "v1.0.0\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := testBouncer(`
+		[proxy]
+		source = "file://` + filepath.ToSlash(dir) + `"
+
+		[cache]
+		go_get = { max_age = "1m" }
+
+		[[packages]]
+		prefix = "example.com/mod"
+		import = "mod self"
+		redirect = "https://docs.example.com/mod"
+		versions.v2 = {}
+
+		[[packages]]
+		prefix = "example.com/Upper"
+		import = ["mod self", "git https://git.example.com/upper"]
+		redirect = "https://docs.example.com/upper"
+
+		[[packages]]
+		prefix = "example.com/git"
+		github = "acme/git"
+	`)
+
+	testCases := []struct {
+		target       string
+		status       int
+		body         string
+		contentType  string
+		cacheControl string
+	}{
+		{
+			target:       "https://example.com/mod?go-get=1",
+			status:       http.StatusOK,
+			body:         `<meta name="go-import" content="example.com/mod mod https://example.com/.well-known/importbounce/proxy">`,
+			contentType:  "text/html; charset=utf-8",
+			cacheControl: "public, max-age=60",
+		},
+		{
+			target:       "https://example.com/.well-known/importbounce/proxy/example.com/mod/@v/list",
+			status:       http.StatusOK,
+			body:         "v1.0.0\nv1.1.0\nv1.2.0-pre\n",
+			contentType:  "text/plain; charset=utf-8",
+			cacheControl: "public, max-age=60",
+		},
+		{
+			target:       "https://example.com/.well-known/importbounce/proxy/example.com/mod/@v/v1.1.0.zip",
+			status:       http.StatusOK,
+			body:         "PK",
+			contentType:  "application/zip",
+			cacheControl: "public, max-age=31536000, immutable",
+		},
+		{
+			target:       "https://example.com/.well-known/importbounce/proxy/example.com/mod/@v/v1.1.0.mod",
+			status:       http.StatusOK,
+			body:         "module example.com/mod\n",
+			contentType:  "text/plain; charset=utf-8",
+			cacheControl: "public, max-age=31536000, immutable",
+		},
+		{
+			// Releases are preferred to pre-releases...
+			target:       "https://example.com/.well-known/importbounce/proxy/example.com/mod/@latest",
+			status:       http.StatusOK,
+			body:         `{"Version":"v1.1.0"}`,
+			contentType:  "application/json",
+			cacheControl: "public, max-age=60",
+		},
+		{
+			// ...but a pre-release is better than nothing.
+			target:       "https://example.com/.well-known/importbounce/proxy/example.com/mod/v2/@latest",
+			status:       http.StatusOK,
+			body:         `{"Version":"v2.0.0-beta"}`,
+			contentType:  "application/json",
+			cacheControl: "public, max-age=60",
+		},
+		{
+			target:       "https://example.com/.well-known/importbounce/proxy/example.com/!upper/@latest",
+			status:       http.StatusOK,
+			body:         `{"Version":"v0.1.0"}`,
+			contentType:  "application/json",
+			cacheControl: "public, max-age=60",
+		},
+		{
+			target: "https://example.com/.well-known/importbounce/proxy/example.com/mod/@v/v1.0.0.info",
+			status: http.StatusNotFound,
+		},
+		{
+			target: "https://example.com/.well-known/importbounce/proxy/example.com/mod/@v/v2.0.0.info",
+			status: http.StatusNotFound,
+		},
+		{
+			target: "https://example.com/.well-known/importbounce/proxy/example.com/mod/sub/@v/list",
+			status: http.StatusNotFound,
+		},
+		{
+			target: "https://example.com/.well-known/importbounce/proxy/example.com/Upper/@latest",
+			status: http.StatusNotFound,
+		},
+		{
+			target: "https://example.com/.well-known/importbounce/proxy/example.com/git/@v/list",
+			status: http.StatusNotFound,
+		},
+		{
+			target: "https://example.com/.well-known/importbounce/proxy/example.com/mod/@v/list.txt",
+			status: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: got status %d; want %d", tc.target, w.Code, tc.status)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("%s: body does not contain %q:\n%s", tc.target, tc.body, w.Body.String())
+		}
+		if got := w.Header().Get("Content-Type"); got != tc.contentType {
+			t.Errorf("%s: got Content-Type %q; want %q", tc.target, got, tc.contentType)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tc.cacheControl {
+			t.Errorf("%s: got Cache-Control %q; want %q", tc.target, got, tc.cacheControl)
+		}
+	}
+}
+
+func TestServeProxyHTTPSource(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/example.com/mod/@v/v1.0.0.zip":
+			w.Header().Set("Content-Length", "2")
+			io.WriteString(w, "PK")
+		case "/example.com/mod/@v/v1.1.0.zip":
+			// Flushing before the end of the body leaves its size unknown.
+			io.WriteString(w, "P")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, "K")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer source.Close()
+
+	b := testBouncer(`
+		[proxy]
+		source = "` + source.URL + `"
+
+		[cache]
+		go_get = { max_age = "1m" }
+
+		[[packages]]
+		prefix = "example.com/mod"
+		import = "mod self"
+		redirect = "https://docs.example.com/mod"
+	`)
+
+	for _, tc := range []struct {
+		file, contentLength, cacheControl string
+	}{
+		{"v1.0.0.zip", "2", "public, max-age=31536000, immutable"},
+		{"v1.1.0.zip", "", "public, max-age=60"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com"+proxyPath+"/example.com/mod/@v/"+tc.file, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "PK" {
+			t.Errorf("%s: got %d %q; want %d %q", tc.file, w.Code, w.Body.String(), http.StatusOK, "PK")
+		}
+		if got := w.Header().Get("Content-Length"); got != tc.contentLength {
+			t.Errorf("%s: got Content-Length %q; want %q", tc.file, got, tc.contentLength)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tc.cacheControl {
+			t.Errorf("%s: got Cache-Control %q; want %q", tc.file, got, tc.cacheControl)
+		}
+	}
+}
+
+func TestServeProxyLocalRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	work, bare, cache := t.TempDir(), filepath.Join(t.TempDir(), "lib.git"), t.TempDir()
+	git := func(dir string, args ...string) { t.Helper(); runGit(t, dir, args...) }
+	write := func(files map[string]string) { t.Helper(); writeFiles(t, work, files) }
+
+	git(work, "init", "-q")
+	write(map[string]string{
+		"go.mod":       "module example.com/lib\n",
+		"lib.go":       "package lib\n",
+		"LICENSE":      "Do what you like.\n",
+		"sub/go.mod":   "module example.com/lib/sub\n",
+		"sub/sub.go":   "package sub\n",
+		"subx/subx.go": "package subx\n",
+		"plain/doc.go": "package plain\n",
+	})
+	git(work, "add", ".")
+	git(work, "commit", "-q", "-m", "v1")
+	for _, tag := range []string{"v1.0.0", "v1.0", "v3.0.0", "sub/v0.1.0", "plain/v1.0.0"} {
+		git(work, "tag", tag)
+	}
+	write(map[string]string{"v2/go.mod": "module example.com/lib/v2\n", "v2/lib.go": "package lib\n"})
+	git(work, "add", ".")
+	git(work, "commit", "-q", "-m", "v2")
+	git(work, "tag", "-a", "-m", "v2.1.0", "v2.1.0")
+	git(filepath.Dir(bare), "clone", "-q", "--bare", work, bare)
+
+	b := testBouncer(`
+		[proxy]
+		cache_dir = "` + filepath.ToSlash(cache) + `"
+
+		[[packages]]
+		prefix = "example.com/lib"
+		import = "mod self"
+		local_repo = "` + filepath.ToSlash(bare) + `"
+		redirect = "https://docs.example.com/lib"
+		versions.v2 = { subdir = "v2" }
+
+		[[packages]]
+		prefix = "example.com/lib/sub"
+		import = "mod self"
+		local_repo = "` + filepath.ToSlash(bare) + `"
+		subdir = "sub"
+		redirect = "https://docs.example.com/lib/sub"
+
+		[[packages]]
+		prefix = "example.com/plain"
+		import = "mod self"
+		local_repo = "` + filepath.ToSlash(bare) + `"
+		subdir = "plain"
+		redirect = "https://docs.example.com/plain"
+	`)
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com"+proxyPath+"/"+target, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		return w
+	}
+
+	for target, want := range map[string]string{
+		"example.com/lib/@v/list":           "v1.0.0\n",
+		"example.com/lib/v2/@v/list":        "v2.1.0\n",
+		"example.com/lib/sub/@v/list":       "v0.1.0\n",
+		"example.com/lib/@latest":           `{"Version":"v1.0.0","Time":"2024-01-02T03:04:05Z"}` + "\n",
+		"example.com/lib/v2/@v/v2.1.0.info": `{"Version":"v2.1.0","Time":"2024-01-02T03:04:05Z"}` + "\n",
+		"example.com/lib/v2/@v/v2.1.0.mod":  "module example.com/lib/v2\n",
+		"example.com/plain/@v/v1.0.0.mod":   "module example.com/plain\n",
+		"example.com/lib/sub/@v/v0.1.0.mod": "module example.com/lib/sub\n",
+	} {
+		w := get(target)
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: got %d %q; want %d %q", target, w.Code, w.Body.String(), http.StatusOK, want)
+		}
+	}
+	for _, target := range []string{
+		"example.com/lib/@v/v1.0.1.info",
+		"example.com/lib/@v/v3.0.0.info",
+		"example.com/plain/v2/@v/list",
+	} {
+		if w := get(target); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d; want %d", target, w.Code, http.StatusNotFound)
+		}
+	}
+
+	for target, want := range map[string][]string{
+		"example.com/lib/@v/v1.0.0.zip": {
+			"example.com/lib@v1.0.0/LICENSE",
+			"example.com/lib@v1.0.0/go.mod",
+			"example.com/lib@v1.0.0/lib.go",
+			"example.com/lib@v1.0.0/plain/doc.go",
+			"example.com/lib@v1.0.0/subx/subx.go",
+		},
+		"example.com/lib/sub/@v/v0.1.0.zip": {
+			"example.com/lib/sub@v0.1.0/LICENSE",
+			"example.com/lib/sub@v0.1.0/go.mod",
+			"example.com/lib/sub@v0.1.0/sub.go",
+		},
+	} {
+		w := get(target)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d; want %d", target, w.Code, http.StatusOK)
+			continue
+		}
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		var got []string
+		for _, f := range zr.File {
+			got = append(got, f.Name)
+		}
+		slices.Sort(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: wrong files\ngot:  %v\nwant: %v", target, got, want)
+		}
+		if _, err := os.Stat(filepath.Join(cache, filepath.FromSlash(target))); err != nil {
+			t.Errorf("%s: not cached: %v", target, err)
+		}
+	}
+
+	// Cached files are served without the repository, which only the list
+	// still needs.
+	if err := os.RemoveAll(bare); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("example.com/lib/@v/v1.0.0.zip"); w.Code != http.StatusOK {
+		t.Errorf("cached zip: got status %d; want %d", w.Code, http.StatusOK)
+	}
+	if w := get("example.com/lib/@v/list"); w.Code == http.StatusOK {
+		t.Errorf("list: got status %d without a repository", w.Code)
+	}
+}
+
+func TestServeGitSelector(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not found")
+	}
+
+	work, root := t.TempDir(), t.TempDir()
+	runGit(t, work, "init", "-q", "-b", "main")
+	commit := func(version string) string {
+		t.Helper()
+		writeFiles(t, work, map[string]string{"version.txt": version + "\n"})
+		runGit(t, work, "add", ".")
+		runGit(t, work, "commit", "-q", "-m", version)
+		return runGit(t, work, "rev-parse", "HEAD")
+	}
+	v1 := commit("1.0.0")
+	runGit(t, work, "tag", "v1.0.0")
+	commit("2.1.0")
+	runGit(t, work, "tag", "-a", "-m", "v2.1.0", "v2.1.0")
+	v2 := commit("2.1.1")
+	runGit(t, work, "branch", "v2.1") // takes precedence over the v2.1.0 tag
+	commit("3.0.0-dev")
+	runGit(t, root, "clone", "-q", "--bare", work, filepath.Join(root, "lib.git"))
+
+	upstream := httptest.NewServer(&cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	})
+	defer upstream.Close()
+
+	b := testBouncer(`
+		[[packages]]
+		prefix = "example.com/lib"
+		import = "git ` + upstream.URL + `/lib.git"
+		redirect = "https://docs.example.com/lib"
+		version_selector = "suffix"
+		source = { forge = "gitea" }
+	`)
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	// Clones can take much longer than the timeout that the command sets for
+	// config loads on http.DefaultClient, which the proxy mustn't use.
+	defer func(timeout time.Duration) { http.DefaultClient.Timeout = timeout }(http.DefaultClient.Timeout)
+	http.DefaultClient.Timeout = time.Nanosecond
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/lib.v2/sub?go-get=1", nil)
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, req)
+	if want := `<meta name="go-import" content="example.com/lib.v2 git https://example.com/lib.v2">`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("go-get response does not contain %s:\n%s", want, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "go-source") {
+		t.Errorf("go-get response links to the default branch's source:\n%s", w.Body.String())
+	}
+
+	for _, tc := range []struct{ path, head, branch string }{
+		{"lib.v1", v1, ""},
+		{"lib.v2", v2, "v2.1"},
+	} {
+		dir := filepath.Join(t.TempDir(), tc.path)
+		runGit(t, "", "-c", "http.extraHeader=Host: example.com", "clone", "-q", server.URL+"/"+tc.path, dir)
+		if got := runGit(t, dir, "rev-parse", "HEAD"); got != tc.head {
+			t.Errorf("%s: cloned HEAD at %s; want %s", tc.path, got, tc.head)
+		}
+		if got, _ := exec.Command("git", "-C", dir, "branch", "--show-current").Output(); strings.TrimSpace(string(got)) != tc.branch {
+			t.Errorf("%s: cloned branch %q; want %q", tc.path, strings.TrimSpace(string(got)), tc.branch)
+		}
+	}
+
+	for _, target := range []string{
+		"https://example.com/lib.v3/info/refs?service=git-upload-pack",
+		"https://example.com/lib/info/refs?service=git-upload-pack",
+		"https://example.com/other.v1/info/refs?service=git-upload-pack",
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d; want %d", target, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+// runGit runs a Git command in dir with a fixed identity and commit time, and
+// returns its trimmed output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_AUTHOR_DATE=2024-01-02T03:04:05Z", "GIT_COMMITTER_DATE=2024-01-02T03:04:05Z",
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		var stderr []byte
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			stderr = exitErr.Stderr
+		}
+		t.Fatalf("git %v: %v\n%s", args, err, stderr)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeFiles writes files with the given contents under dir, keyed by their
+// slash-separated paths.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testBouncer returns a Bouncer that loads configTOML on every request.
+func testBouncer(configTOML string) *Bouncer {
+	return &Bouncer{
+		fetchConfig: func(_ context.Context, _ configVersion) (io.ReadCloser, configVersion, error) {
+			return io.NopCloser(strings.NewReader(configTOML)), configVersion{}, nil
+		},
+	}
+}
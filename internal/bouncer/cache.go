@@ -0,0 +1,236 @@
+package bouncer
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"html/template"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheConfig holds the caching policy for each kind of response. A policy
+// that isn't set for a package falls back to the one for its host, and then to
+// the one at the top level of the config. Responses without any policy have
+// no Cache-Control header.
+type cacheConfig struct {
+	GoGet    *cachePolicy `toml:"go_get"`   // responses to the go command and other tools
+	Redirect *cachePolicy `toml:"redirect"` // redirects and notices for web browsers
+	NotFound *cachePolicy `toml:"not_found"`
+}
+
+// cachePolicy describes the Cache-Control header for a response, either
+// through its common directives or as a literal header value.
+type cachePolicy struct {
+	MaxAge               time.Duration `toml:"max_age"`
+	StaleWhileRevalidate time.Duration `toml:"stale_while_revalidate"`
+	CacheControl         string        `toml:"cache_control"`
+}
+
+// redirectStatuses are the allowed values for redirect_status settings.
+var redirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// inherit returns the policies of c, with those that aren't set taken from
+// parent.
+func (c cacheConfig) inherit(parent cacheConfig) cacheConfig {
+	return cacheConfig{
+		GoGet:    cmp.Or(c.GoGet, parent.GoGet),
+		Redirect: cmp.Or(c.Redirect, parent.Redirect),
+		NotFound: cmp.Or(c.NotFound, parent.NotFound),
+	}
+}
+
+// header returns the Cache-Control header value for the policy, or "" if the
+// policy is nil.
+func (p *cachePolicy) header() string {
+	switch {
+	case p == nil:
+		return ""
+	case p.CacheControl != "":
+		return p.CacheControl
+	}
+	header := fmt.Sprintf("public, max-age=%d", int64(p.MaxAge.Seconds()))
+	if p.StaleWhileRevalidate > 0 {
+		header += fmt.Sprintf(", stale-while-revalidate=%d", int64(p.StaleWhileRevalidate.Seconds()))
+	}
+	return header
+}
+
+// validate checks the policies, which are found under key relative to the key
+// of report (or directly at that key if key is empty).
+func (c *cacheConfig) validate(report reportFunc, key string) {
+	for _, policy := range []struct {
+		name string
+		*cachePolicy
+	}{
+		{"go_get", c.GoGet},
+		{"redirect", c.Redirect},
+		{"not_found", c.NotFound},
+	} {
+		if policy.cachePolicy == nil {
+			continue
+		}
+		field := policy.name
+		if key != "" {
+			field = key + "." + field
+		}
+		if policy.MaxAge < 0 || policy.StaleWhileRevalidate < 0 {
+			report(field, "%s: durations must not be negative", field)
+		}
+		if policy.CacheControl != "" && (policy.MaxAge != 0 || policy.StaleWhileRevalidate != 0) {
+			report(field, "%s: cache_control can't be combined with max_age or stale_while_revalidate", field)
+		}
+	}
+}
+
+// checkRedirectStatus validates a redirect_status setting.
+func checkRedirectStatus(status int) error {
+	if status != 0 && !slices.Contains(redirectStatuses, status) {
+		return fmt.Errorf("redirect_status must be %d, %d, %d or %d",
+			redirectStatuses[0], redirectStatuses[1], redirectStatuses[2], redirectStatuses[3])
+	}
+	return nil
+}
+
+// responseSettings are the settings that shape a response, combined from the
+// package, host and top-level settings that apply to it.
+type responseSettings struct {
+	cache          cacheConfig
+	redirectStatus int
+	etag           string
+}
+
+// responseSettings returns the settings for a response from the host and
+// package, either of which may be nil.
+func (c *config) responseSettings(h *hostConfig, p *packageConfig) responseSettings {
+	rs := responseSettings{
+		cache:          c.Cache,
+		redirectStatus: c.RedirectStatus,
+		etag:           c.etag,
+	}
+	if h != nil {
+		rs.cache = h.Cache.inherit(rs.cache)
+		rs.redirectStatus = cmp.Or(h.RedirectStatus, rs.redirectStatus)
+	}
+	if p != nil {
+		rs.cache = p.Cache.inherit(rs.cache)
+		rs.redirectStatus = cmp.Or(p.RedirectStatus, rs.redirectStatus)
+	}
+	rs.redirectStatus = cmp.Or(rs.redirectStatus, http.StatusFound)
+	return rs
+}
+
+// setHeaders sets the caching headers for a response with the given policy.
+func (rs responseSettings) setHeaders(w http.ResponseWriter, policy *cachePolicy) {
+	if header := policy.header(); header != "" {
+		w.Header().Set("Cache-Control", header)
+	}
+	if rs.etag != "" {
+		w.Header().Set("ETag", rs.etag)
+	}
+}
+
+// serveRedirect serves a redirect with the given status, or the configured
+// status for redirects if status is 0.
+func (rs responseSettings) serveRedirect(w http.ResponseWriter, r *http.Request, url string, status int) {
+	rs.setHeaders(w, rs.cache.Redirect)
+	http.Redirect(w, r, url, cmp.Or(status, rs.redirectStatus))
+}
+
+// serveError serves a plain text error response, like a 404 or 410, with the
+// caching policy for responses that aren't found.
+func (rs responseSettings) serveError(w http.ResponseWriter, body string, status int) {
+	rs.setHeaders(w, rs.cache.NotFound)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// servePage serves an HTML page with the given caching policy, or a 304
+// response if the request has a matching If-None-Match header.
+func (rs responseSettings) servePage(w http.ResponseWriter, r *http.Request, policy *cachePolicy, tmpl *template.Template, data any) error {
+	if rs.serveNotModified(w, r, policy) {
+		return nil
+	}
+	return tmpl.Execute(w, data)
+}
+
+// serveJSON is like servePage, for a JSON document. The document has its own
+// ETag, since it may be served at the same URL as a page.
+func (rs responseSettings) serveJSON(w http.ResponseWriter, r *http.Request, policy *cachePolicy, v any) error {
+	if rs.etag != "" {
+		rs.etag = strings.TrimSuffix(rs.etag, `"`) + `-json"`
+	}
+	if rs.serveNotModified(w, r, policy) {
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// serveNotModified sets the caching headers for a response with the given
+// policy, and serves a 304 response if the request has a matching
+// If-None-Match header, reporting whether it did.
+func (rs responseSettings) serveNotModified(w http.ResponseWriter, r *http.Request, policy *cachePolicy) bool {
+	rs.setHeaders(w, policy)
+	if rs.etag != "" && etagMatches(r.Header.Get("If-None-Match"), rs.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the
+// weak comparison that RFC 9110 specifies for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// newConfigHash returns a hash for computing the ETag of a config from its
+// content. Responses also depend on the code that generates them, so the hash
+// starts with the identity of the running build.
+func newConfigHash() hash.Hash {
+	h := sha256.New()
+	h.Write([]byte(buildID()))
+	return h
+}
+
+// configETag formats the ETag for a config from its hash.
+func configETag(h hash.Hash) string {
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+}
+
+// buildID identifies the running build as precisely as its build info allows.
+var buildID = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	id := info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" || setting.Key == "vcs.modified" {
+			id += " " + setting.Value
+		}
+	}
+	return id
+})
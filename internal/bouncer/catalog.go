@@ -0,0 +1,123 @@
+package bouncer
+
+import (
+	"cmp"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// catalogPath is the URL path, reserved on every host, of the JSON catalog of
+// the packages that the host serves.
+const catalogPath = "/.well-known/importbounce/packages.json"
+
+// catalog is the JSON document served at catalogPath.
+type catalog struct {
+	Host     string         `json:"host"`
+	Packages []catalogEntry `json:"packages"`
+}
+
+// catalogEntry describes a package, or a major version of a package with its
+// own settings, in machine-readable form. The entry for a package with
+// placeholders in its prefix keeps the placeholders in its other settings.
+type catalogEntry struct {
+	Prefix          string `json:"prefix"`
+	VCS             string `json:"vcs,omitempty"`
+	RepoRoot        string `json:"repo_root,omitempty"`
+	Subdir          string `json:"subdir,omitempty"`
+	ModuleProxy     string `json:"module_proxy,omitempty"`
+	VersionSelector string `json:"version_selector,omitempty"`
+	Redirect        string `json:"redirect,omitempty"`
+	Status          string `json:"status"`
+	Successor       string `json:"successor,omitempty"`
+	Description     string `json:"description,omitempty"`
+}
+
+// catalog returns the catalog of the packages that these settings serve on
+// host.
+func (h *hostConfig) catalog(host string) catalog {
+	c := catalog{Host: cmp.Or(h.name, host), Packages: []catalogEntry{}}
+	for _, p := range h.packagesOn(host) {
+		c.Packages = append(c.Packages, p.forCatalog(p.Prefix).catalogEntry())
+		for _, major := range p.majors() {
+			c.Packages = append(c.Packages, p.forCatalog(p.Prefix+"/"+major).catalogEntry())
+		}
+	}
+	return c
+}
+
+// forCatalog returns the settings of the package for path, which is either the
+// package's prefix or the prefix followed by one of its major versions.
+// Placeholders are expanded unless the package is a pattern.
+func (p packageConfig) forCatalog(path string) packageConfig {
+	if !p.isPattern() {
+		return p.resolve(path)
+	}
+	if path != p.Prefix {
+		p = p.forVersion(strings.TrimPrefix(path, p.Prefix+"/"))
+		p.Prefix = path
+	}
+	return p
+}
+
+// catalogEntry returns the catalog entry for a package whose settings are
+// already resolved for the entry's prefix.
+func (p packageConfig) catalogEntry() catalogEntry {
+	entry := catalogEntry{
+		Prefix:          p.Prefix,
+		Subdir:          p.Subdir,
+		ModuleProxy:     p.moduleProxyURL(),
+		VersionSelector: p.VersionSelector,
+		Status:          cmp.Or(p.Status, statusActive),
+		Successor:       p.Successor,
+		Description:     p.Description,
+	}
+	entry.VCS, entry.RepoRoot, _ = p.Import.repoRoot()
+	if p.Status != statusRetired {
+		entry.Redirect = expandPlaceholders(p.Redirect, map[string]string{queryVar: ""})
+	}
+	return entry
+}
+
+// prefersJSON reports whether the Accept header of a request ranks JSON above
+// HTML, as for a client that wants a package's catalog entry rather than a
+// page for web visitors.
+func prefersJSON(r *http.Request) bool {
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	return acceptQuality(accept, "application/json") > acceptQuality(accept, "text/html")
+}
+
+// acceptQuality returns the quality value that an Accept header gives to a
+// media type, from the most specific of the header's ranges that matches it.
+func acceptQuality(accept, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, item := range strings.Split(accept, ",") {
+		itemType, params, err := mime.ParseMediaType(item)
+		if err != nil {
+			continue
+		}
+		var s int
+		switch itemType {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if s > specificity {
+			quality, specificity = q, s
+		}
+	}
+	return quality
+}
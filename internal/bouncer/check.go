@@ -0,0 +1,215 @@
+package bouncer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A Problem is an issue found in a config file by Check.
+type Problem struct {
+	Line    int // The line of the config file with the problem, or 0 if unknown.
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// Check fetches the config file from the provided URL (see New for supported
+// schemes) and reports any problems with it, using the same decoding and
+// validation that a Bouncer uses at runtime. An error is returned only if the
+// config file could not be fetched.
+func Check(ctx context.Context, configURL string) ([]Problem, error) {
+	fetchConfig, err := getFetcherFromURL(configURL)
+	if err != nil {
+		return nil, err
+	}
+
+	r, _, err := fetchConfig(ctx, configVersion{})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	_, err = decodeConfig(bytes.NewReader(data))
+	if err == nil {
+		return nil, nil
+	}
+
+	var (
+		parseErr  toml.ParseError
+		configErr configErrors
+	)
+	switch {
+	case errors.As(err, &parseErr):
+		// ParseError doesn't expose its message separately from its position
+		// in every case, so strip the position back off.
+		line := parseErr.Position.Line
+		prefix := fmt.Sprintf("toml: line %d", line)
+		if parseErr.LastKey != "" {
+			prefix += fmt.Sprintf(" (last key %q)", parseErr.LastKey)
+		}
+		msg := strings.TrimPrefix(parseErr.Error(), prefix+": ")
+		return []Problem{{Line: line, Message: "syntax error: " + msg}}, nil
+
+	case errors.As(err, &configErr):
+		lines := keyLines(data)
+		problems := make([]Problem, len(configErr))
+		for i, err := range configErr {
+			key := err.Key
+			if err.Field != "" {
+				key += "." + err.Field
+			}
+			problems[i] = Problem{Line: lines.find(key), Message: err.Error()}
+		}
+		slices.SortStableFunc(problems, func(a, b Problem) int { return a.Line - b.Line })
+		return problems, nil
+
+	default:
+		return []Problem{{Message: err.Error()}}, nil
+	}
+}
+
+// lineIndex maps the key paths used in configErrors to the lines of a TOML
+// file where they are defined.
+type lineIndex map[string]int
+
+// find returns the line on which key or its closest parent is defined, or 0 if
+// none are found.
+func (idx lineIndex) find(key string) int {
+	for {
+		if line, ok := idx[key]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i < 0 {
+			return 0
+		}
+		key = key[:i]
+	}
+}
+
+// keyLines builds a lineIndex for a TOML file that is known to parse. It only
+// understands as much TOML syntax as it needs to find table headers and keys
+// at the start of a line, which covers the way configs are normally written.
+func keyLines(data []byte) lineIndex {
+	var (
+		idx      = make(lineIndex)
+		counts   = make(map[string]int)
+		table    []string
+		inString string // the delimiter of a multi-line string in progress
+	)
+
+	// indexed renders a key path in the same form as undecodedKeys, with the
+	// current index of each array table that it passes through.
+	indexed := func(key []string) string {
+		var b strings.Builder
+		for i := range key {
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(toml.Key(key[i : i+1]).String())
+			if n, ok := counts[toml.Key(key[:i+1]).String()]; ok {
+				fmt.Fprintf(&b, "[%d]", n-1)
+			}
+		}
+		return b.String()
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if inString != "" {
+			if strings.Contains(line, inString) {
+				inString = ""
+			}
+			continue
+		}
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+
+		case strings.HasPrefix(line, "[["):
+			end := strings.Index(line, "]]")
+			if end < 0 {
+				continue
+			}
+			table = splitKey(line[2:end])
+			counts[toml.Key(table).String()]++
+			idx[indexed(table)] = lineNum
+
+		case strings.HasPrefix(line, "["):
+			end := strings.Index(line, "]")
+			if end < 0 {
+				continue
+			}
+			table = splitKey(line[1:end])
+			idx[indexed(table)] = lineNum
+
+		default:
+			name, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			key := append(append([]string(nil), table...), splitKey(name)...)
+			// The key itself is not an array table, even if one shares its
+			// name, so its final component is rendered without an index.
+			path := indexed(key[:len(key)-1])
+			if path != "" {
+				path += "."
+			}
+			idx[path+toml.Key(key[len(key)-1:]).String()] = lineNum
+
+			value = strings.TrimSpace(value)
+			for _, delim := range []string{`"""`, `'''`} {
+				if strings.HasPrefix(value, delim) && !strings.Contains(value[len(delim):], delim) {
+					inString = delim
+				}
+			}
+		}
+	}
+	return idx
+}
+
+// splitKey splits a dotted TOML key into its components, removing quotes.
+func splitKey(s string) []string {
+	var (
+		parts []string
+		part  strings.Builder
+		quote rune
+	)
+	for _, r := range strings.TrimSpace(s) {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			part.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '.':
+			parts = append(parts, strings.TrimSpace(part.String()))
+			part.Reset()
+		case r != ' ' && r != '\t':
+			part.WriteRune(r)
+		}
+	}
+	return append(parts, part.String())
+}
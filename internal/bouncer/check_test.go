@@ -0,0 +1,46 @@
+package bouncer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	const configTOML = `# An invalid config.
+default_redirect = "https://example.com"
+
+[[packages]]
+prefix = "example.com/a"
+import = "git https://github.com/example/a"
+redirect = "https://pkg.go.dev/example.com/a"
+
+[[packages]]
+prefix = "example.com/b"
+summary = """
+prefix = "this is not a key"
+"""
+import = "gti https://github.com/example/b"
+redirect = "https://pkg.go.dev/example.com/b"
+`
+
+	path := filepath.Join(t.TempDir(), "importbounce.toml")
+	if err := os.WriteFile(path, []byte(configTOML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := Check(context.Background(), "file://"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Problem{
+		{Line: 11, Message: `packages[1].summary: unknown key`},
+		{Line: 14, Message: `packages[1] (prefix "example.com/b"): import "gti https://github.com/example/b" has unknown VCS "gti" (want one of bzr, fossil, git, hg, mod, svn)`},
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("wrong problems\ngot:  %v\nwant: %v", problems, want)
+	}
+}
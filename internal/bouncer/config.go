@@ -1,32 +1,294 @@
 package bouncer
 
-import "strings"
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
 
 type config struct {
-	DefaultRedirect string          `toml:"default_redirect"`
-	Packages        []packageConfig `toml:"packages"`
+	DefaultRedirect string                 `toml:"default_redirect"`
+	Packages        []packageConfig        `toml:"packages"`
+	Hosts           map[string]*hostConfig `toml:"hosts"`
+	UnknownHost     *unknownHostConfig     `toml:"unknown_host"`
+	Server          serverConfig           `toml:"server"`
+	Proxy           *proxyConfig           `toml:"proxy"`
+	RedirectStatus  int                    `toml:"redirect_status"`
+	Cache           cacheConfig            `toml:"cache"`
+
+	// IndexPage and IndexPageTemplate enable an index page for every host
+	// that the top-level packages are on, and set the default template for
+	// the index pages of hosts with their own sections.
+	IndexPage         bool   `toml:"index_page"`
+	IndexPageTemplate string `toml:"index_page_template"`
+
+	etag     string // identifies the content of the config
+	topLevel *hostConfig
+	hosts    map[string]*hostConfig // keyed by lowercase name and alias
 }
 
 type packageConfig struct {
-	Prefix   string `toml:"prefix"`
-	Import   string `toml:"import"`
-	Redirect string `toml:"redirect"`
+	Prefix   string       `toml:"prefix"`
+	Import   importList   `toml:"import"`
+	Subdir   string       `toml:"subdir"`
+	Redirect string       `toml:"redirect"`
+	Source   sourceConfig `toml:"source"`
+
+	// LocalRepo is the directory of a Git repository on the local filesystem,
+	// from whose tags the built-in module proxy generates the versions of
+	// the package's module, in the subdirectory given by Subdir.
+	LocalRepo string `toml:"local_repo"`
+
+	// Description is a short summary of the package for index pages.
+	Description string `toml:"description"`
+
+	// Status marks a package that is deprecated, moved or retired, with an
+	// optional message for users and the import path of its successor.
+	Status    string `toml:"status"`
+	Message   string `toml:"message"`
+	Successor string `toml:"successor"`
+
+	// RedirectStatus and Cache override the settings of the same name for
+	// the package's host or the config as a whole.
+	RedirectStatus int         `toml:"redirect_status"`
+	Cache          cacheConfig `toml:"cache"`
+
+	// Versions holds settings for major versions of the package, keyed by
+	// their suffix (like "v2"), for paths under the package's prefix that
+	// continue with that suffix.
+	Versions map[string]versionConfig `toml:"versions"`
+
+	// VersionSelector lets paths select a major version of the package in
+	// the style of gopkg.in, with either a suffix on the prefix (like
+	// "example.com/pkg.v3") or a segment after the host (like
+	// "example.com/v3/pkg"). The go command clones such a path through the
+	// Bouncer, which proxies the package's Git repository with its HEAD at
+	// the newest branch or tag for the selected version.
+	VersionSelector string `toml:"version_selector"`
+
+	// Forge shorthands, which fill in the settings above for a repository on
+	// a well-known forge, given its path (like "owner/repo") on that forge.
+	GitHub    string `toml:"github"`
+	GitLab    string `toml:"gitlab"`
+	Codeberg  string `toml:"codeberg"`
+	Sourcehut string `toml:"sourcehut"`
+	Bitbucket string `toml:"bitbucket"`
+
+	selector string // the major version that the requested path selects
+}
+
+// decodeConfig decodes a TOML config and validates it, so that a config that
+// decodes without error is safe to serve.
+func decodeConfig(r io.Reader) (config, error) {
+	var c config
+	h := newConfigHash()
+	md, err := toml.NewDecoder(io.TeeReader(r, h)).Decode(&c)
+	if err != nil {
+		return config{}, err
+	}
+	c.etag = configETag(h)
+	c.expandHosts()
+	c.expandShorthands()
+	if errs := c.validate(md); len(errs) > 0 {
+		return config{}, errs
+	}
+	c.buildIndex()
+	return c, nil
 }
 
+// FindPackage returns the package that matches a full segment of path, with
+// its prefix and any placeholders in its settings filled in from path. Only
+// packages for the host at the start of path are considered; see lookupHost.
+//
+// A package with an explicit prefix takes precedence over one whose prefix
+// contains placeholders. Among packages of the same kind, the one with the
+// longest matching prefix is used, regardless of the order of packages in the
+// config.
 func (c *config) FindPackage(path string) packageConfig {
-	for _, pkgConf := range c.Packages {
-		prefix := strings.TrimSuffix(pkgConf.Prefix, "/")
-		if !strings.HasPrefix(path, prefix) {
-			continue
+	host, _, _ := strings.Cut(path, "/")
+	if h := c.lookupHost(host); h != nil {
+		return h.findPackage(path)
+	}
+	return packageConfig{}
+}
+
+// resolve returns a copy of the package config for a request for path, which
+// must match the package's prefix. If path continues with one of the package's
+// major versions, the copy has that version's settings. The prefix of the copy
+// is the part of path that matched, including any major version, and
+// placeholders in its other settings are expanded with the
+// values of the corresponding prefix segments and the parts of the path. Only
+// the {query} placeholder, which doesn't come from the path, is left alone.
+func (p packageConfig) resolve(path string) packageConfig {
+	prefixSegments := splitPath(p.Prefix)
+	pathSegments := splitPath(path)
+
+	n, major := p.versionedPrefix(pathSegments, len(prefixSegments))
+	if major != "" {
+		p = p.forVersion(major)
+	}
+
+	// The path of a request with a version selector has the selector removed,
+	// but the selector is part of the prefix that the go command sees.
+	requested, prefixLen := pathSegments, n
+	if p.selector != "" {
+		requested = p.withSelector(pathSegments, len(prefixSegments))
+		prefixLen += len(requested) - len(pathSegments)
+	}
+
+	vars := map[string]string{
+		pathVar:  strings.Join(requested, "/"),
+		hostVar:  pathSegments[0],
+		restVar:  strings.Join(pathSegments[n:], "/"),
+		majorVar: cmp.Or(major, p.selector),
+	}
+	for i, segment := range prefixSegments {
+		if name, ok := placeholderName(segment); ok {
+			vars[name] = pathSegments[i]
+		}
+	}
+
+	p.Prefix = strings.Join(requested[:prefixLen], "/")
+	p.Import = p.Import.expand(vars)
+	p.Subdir = expandPlaceholders(p.Subdir, vars)
+	p.LocalRepo = expandPlaceholders(p.LocalRepo, vars)
+	p.Source.Home = expandPlaceholders(p.Source.Home, vars)
+	p.Source.Directory = expandPlaceholders(p.Source.Directory, vars)
+	p.Source.File = expandPlaceholders(p.Source.File, vars)
+	p.Redirect = expandPlaceholders(p.Redirect, vars)
+	p.Successor = expandPlaceholders(p.Successor, vars)
+	return p
+}
+
+// GoImports returns the content of each go-import meta tag for the package,
+// with any "mod" entry first as the go command requires. Every tag has the
+// same prefix, so that the go command will consider all of them.
+func (p packageConfig) GoImports() []string {
+	if p.selector != "" {
+		// The go command clones the repository through the Bouncer, which
+		// serves it with HEAD at the selected version.
+		imp := p.Prefix + " git https://" + p.Prefix
+		if p.Subdir != "" {
+			imp += " " + p.Subdir
 		}
+		return []string{imp}
+	}
+
+	imports := slices.Clone(p.Import)
+	slices.SortStableFunc(imports, func(a, b string) int {
+		aMod, bMod := importVCS(a) == "mod", importVCS(b) == "mod"
+		switch {
+		case aMod && !bMod:
+			return -1
+		case bMod && !aMod:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	for i, imp := range imports {
+		if importVCS(imp) == "mod" {
+			imp = "mod " + p.moduleProxyURL()
+		}
+		imports[i] = p.Prefix + " " + imp
+		if p.Subdir != "" && importVCS(imp) != "mod" {
+			imports[i] += " " + p.Subdir
+		}
+	}
+	return imports
+}
 
-		rest := path[len(prefix):]
-		if len(rest) != 0 && !strings.HasPrefix(rest, "/") {
+// isPattern reports whether the package's prefix contains placeholders.
+func (p *packageConfig) isPattern() bool {
+	return strings.Contains(p.Prefix, "{")
+}
+
+// matchPrefix reports whether a package prefix matches a full segment of the
+// requested import path, and if not, why not.
+func matchPrefix(prefix, path string) (ok bool, reason string) {
+	prefixSegments := splitPath(prefix)
+	pathSegments := splitPath(path)
+
+	for i, segment := range prefixSegments {
+		if _, ok := placeholderName(segment); ok && i < len(pathSegments) {
+			continue
+		}
+		switch {
+		case i < len(pathSegments) && pathSegments[i] == segment:
 			continue
+		case i == len(prefixSegments)-1 && i < len(pathSegments) && strings.HasPrefix(pathSegments[i], segment):
+			return false, "prefix ends in the middle of a path segment"
+		default:
+			return false, "path does not start with prefix"
 		}
+	}
+
+	return true, ""
+}
 
-		return pkgConf
+// importList holds the "vcs repo-root" entries for a package's go-import meta
+// tags. It decodes from either a single string or an array of strings.
+type importList []string
+
+func (l *importList) UnmarshalTOML(v any) error {
+	switch v := v.(type) {
+	case string:
+		*l = importList{v}
+		return nil
+	case []any:
+		list := make(importList, len(v))
+		for i, elem := range v {
+			imp, ok := elem.(string)
+			if !ok {
+				return fmt.Errorf("import list must only contain strings, not %T", elem)
+			}
+			list[i] = imp
+		}
+		*l = list
+		return nil
+	default:
+		return fmt.Errorf("import must be a string or an array of strings, not %T", v)
 	}
+}
 
-	return packageConfig{}
+// expand returns a copy of the list with placeholders expanded.
+func (l importList) expand(vars map[string]string) importList {
+	expanded := make(importList, len(l))
+	for i, imp := range l {
+		expanded[i] = expandPlaceholders(imp, vars)
+	}
+	return expanded
+}
+
+// repoRoot returns the VCS and repository root of the first entry in the list
+// that refers to a VCS repository rather than a module proxy.
+func (l importList) repoRoot() (vcs, root string, ok bool) {
+	for _, imp := range l {
+		if vcs, root, ok := strings.Cut(strings.TrimSpace(imp), " "); ok && vcs != "mod" {
+			return vcs, strings.TrimSpace(root), true
+		}
+	}
+	return "", "", false
+}
+
+// moduleProxy returns the URL of the module proxy in the list, or "" if the
+// list has no "mod" entry.
+func (l importList) moduleProxy() string {
+	for _, imp := range l {
+		if vcs, root, ok := strings.Cut(strings.TrimSpace(imp), " "); ok && vcs == "mod" {
+			return strings.TrimSpace(root)
+		}
+	}
+	return ""
+}
+
+// importVCS returns the VCS of a single "vcs repo-root" import entry.
+func importVCS(imp string) string {
+	vcs, _, _ := strings.Cut(strings.TrimSpace(imp), " ")
+	return vcs
 }
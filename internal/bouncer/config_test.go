@@ -1,7 +1,10 @@
 package bouncer
 
 import (
+	"errors"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -10,11 +13,17 @@ func TestFindPackage(t *testing.T) {
 		Packages: []packageConfig{
 			{
 				Prefix:   "go.alexhamlin.co/importbounce",
-				Import:   "git https://github.com/ahamlinman/importbounce",
+				Import:   importList{"git https://github.com/ahamlinman/importbounce"},
 				Redirect: "https://github.com/ahamlinman/importbounce",
 			},
+			{
+				Prefix:   "go.alexhamlin.co/importbounce/internal/nested",
+				Import:   importList{"git https://github.com/ahamlinman/nested"},
+				Redirect: "https://github.com/ahamlinman/nested",
+			},
 		},
 	}
+	conf.buildIndex()
 
 	testCases := []struct {
 		path string
@@ -30,6 +39,21 @@ func TestFindPackage(t *testing.T) {
 			want: conf.Packages[0],
 		},
 
+		{
+			path: "go.alexhamlin.co/importbounce/internal/nested",
+			want: conf.Packages[1],
+		},
+
+		{
+			path: "go.alexhamlin.co/importbounce/internal/nested/pkg",
+			want: conf.Packages[1],
+		},
+
+		{
+			path: "go.alexhamlin.co/importbounce/internal/nestedxyz",
+			want: conf.Packages[0],
+		},
+
 		{
 			path: "go.alexhamlin.co",
 			want: packageConfig{},
@@ -50,3 +74,787 @@ func TestFindPackage(t *testing.T) {
 		})
 	}
 }
+
+func TestFindPackagePattern(t *testing.T) {
+	conf := &config{
+		Packages: []packageConfig{
+			{
+				Prefix:   "example.com/{repo}",
+				Import:   importList{"git https://github.com/acme/{repo}"},
+				Redirect: "https://pkg.go.dev/example.com/{repo}{/rest}",
+			},
+			{
+				Prefix:   "example.com/special",
+				Import:   importList{"git https://git.example.com/special"},
+				Redirect: "https://example.com/special",
+			},
+			{
+				Prefix:   "example.com/{repo}/contrib/{name}",
+				Import:   importList{"git https://github.com/acme-contrib/{repo}-{name}"},
+				Redirect: "https://github.com/acme-contrib/{repo}-{name}",
+			},
+		},
+	}
+	conf.buildIndex()
+
+	testCases := []struct {
+		path string
+		want packageConfig
+	}{
+		{
+			path: "example.com/widget",
+			want: packageConfig{
+				Prefix:   "example.com/widget",
+				Import:   importList{"git https://github.com/acme/widget"},
+				Redirect: "https://pkg.go.dev/example.com/widget",
+			},
+		},
+
+		{
+			path: "example.com/widget/sub/pkg",
+			want: packageConfig{
+				Prefix:   "example.com/widget",
+				Import:   importList{"git https://github.com/acme/widget"},
+				Redirect: "https://pkg.go.dev/example.com/widget/sub/pkg",
+			},
+		},
+
+		{
+			path: "example.com/special/sub",
+			want: conf.Packages[1],
+		},
+
+		{
+			path: "example.com/widget/contrib/gadget/sub",
+			want: packageConfig{
+				Prefix:   "example.com/widget/contrib/gadget",
+				Import:   importList{"git https://github.com/acme-contrib/widget-gadget"},
+				Redirect: "https://github.com/acme-contrib/widget-gadget",
+			},
+		},
+
+		{
+			path: "example.com",
+			want: packageConfig{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got := conf.FindPackage(tc.path)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FindPackage(%s) = %v; want %v", tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFindPackageVersions(t *testing.T) {
+	conf := &config{
+		Packages: []packageConfig{
+			{
+				Prefix:   "example.com/lib",
+				Import:   importList{"git https://github.com/acme/lib"},
+				Redirect: "https://pkg.go.dev/example.com/lib{/major}{/rest}",
+				Versions: map[string]versionConfig{
+					"v2": {Import: importList{"git https://github.com/acme/lib-v2"}},
+					"v3": {Subdir: "v3", Branch: "release-v3"},
+					"v4": {Redirect: "https://example.com/lib/v4"},
+				},
+			},
+		},
+	}
+	conf.buildIndex()
+
+	testCases := []struct {
+		path string
+		want packageConfig
+	}{
+		{
+			path: "example.com/lib/sub",
+			want: packageConfig{
+				Prefix:   "example.com/lib",
+				Import:   importList{"git https://github.com/acme/lib"},
+				Redirect: "https://pkg.go.dev/example.com/lib/sub",
+				Versions: conf.Packages[0].Versions,
+			},
+		},
+		{
+			path: "example.com/lib/v2/sub",
+			want: packageConfig{
+				Prefix:   "example.com/lib/v2",
+				Import:   importList{"git https://github.com/acme/lib-v2"},
+				Redirect: "https://pkg.go.dev/example.com/lib/v2/sub",
+			},
+		},
+		{
+			path: "example.com/lib/v3",
+			want: packageConfig{
+				Prefix:   "example.com/lib/v3",
+				Import:   importList{"git https://github.com/acme/lib"},
+				Subdir:   "v3",
+				Source:   sourceConfig{Branch: "release-v3"},
+				Redirect: "https://pkg.go.dev/example.com/lib/v3",
+			},
+		},
+		{
+			path: "example.com/lib/v4/sub",
+			want: packageConfig{
+				Prefix:   "example.com/lib/v4",
+				Import:   importList{"git https://github.com/acme/lib"},
+				Redirect: "https://example.com/lib/v4",
+			},
+		},
+		{
+			// Major versions that aren't configured are just subdirectories.
+			path: "example.com/lib/v5",
+			want: packageConfig{
+				Prefix:   "example.com/lib",
+				Import:   importList{"git https://github.com/acme/lib"},
+				Redirect: "https://pkg.go.dev/example.com/lib/v5",
+				Versions: conf.Packages[0].Versions,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got := conf.FindPackage(tc.path)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FindPackage(%s) = %v; want %v", tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFindPackageSelector(t *testing.T) {
+	conf := &config{
+		Packages: []packageConfig{
+			{
+				Prefix:          "example.com/yaml",
+				Import:          importList{"git https://github.com/acme/yaml"},
+				Redirect:        "https://pkg.go.dev/{path}",
+				VersionSelector: "suffix",
+			},
+			{
+				Prefix:          "example.com/tools/{repo}",
+				Import:          importList{"git https://github.com/acme/{repo}"},
+				Redirect:        "https://example.com/docs/{repo}{/major}",
+				VersionSelector: "path",
+			},
+			{
+				Prefix:   "example.com/plain",
+				Import:   importList{"git https://github.com/acme/plain"},
+				Redirect: "https://pkg.go.dev/{path}",
+			},
+		},
+	}
+	conf.buildIndex()
+
+	testCases := []struct {
+		path string
+		want packageConfig
+	}{
+		{
+			path: "example.com/yaml.v3/sub",
+			want: packageConfig{
+				Prefix:          "example.com/yaml.v3",
+				Import:          importList{"git https://github.com/acme/yaml"},
+				Redirect:        "https://pkg.go.dev/example.com/yaml.v3/sub",
+				VersionSelector: "suffix",
+				selector:        "v3",
+			},
+		},
+		{
+			path: "example.com/yaml",
+			want: packageConfig{
+				Prefix:          "example.com/yaml",
+				Import:          importList{"git https://github.com/acme/yaml"},
+				Redirect:        "https://pkg.go.dev/example.com/yaml",
+				VersionSelector: "suffix",
+			},
+		},
+		{
+			path: "example.com/v2/tools/fmt/sub",
+			want: packageConfig{
+				Prefix:          "example.com/v2/tools/fmt",
+				Import:          importList{"git https://github.com/acme/fmt"},
+				Redirect:        "https://example.com/docs/fmt/v2",
+				VersionSelector: "path",
+				selector:        "v2",
+			},
+		},
+		{
+			// A selector in a form that the package doesn't support is just
+			// part of the path.
+			path: "example.com/tools/fmt.v2",
+			want: packageConfig{
+				Prefix:          "example.com/tools/fmt.v2",
+				Import:          importList{"git https://github.com/acme/fmt.v2"},
+				Redirect:        "https://example.com/docs/fmt.v2",
+				VersionSelector: "path",
+			},
+		},
+		{
+			path: "example.com/plain.v2",
+			want: packageConfig{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got := conf.FindPackage(tc.path)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FindPackage(%s) = %v; want %v", tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func FuzzFindPackage(f *testing.F) {
+	conf, err := decodeConfig(strings.NewReader(`
+		[[packages]]
+		prefix = "example.com/a"
+		github = "acme/a"
+		version_selector = "suffix"
+
+		[[packages]]
+		prefix = "example.com/{repo}/v2"
+		github = "acme/{repo}"
+
+		[hosts."go.example.com"]
+		aliases = ["www.go.example.com"]
+
+		[[hosts."go.example.com".packages]]
+		prefix = "{repo}"
+		github = "acme/{repo}"
+	`))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, seed := range [][2]string{
+		{"example.com", "/a/b"},
+		{"EXAMPLE.com:443", "//a/./b/../c.git/"},
+		{"www.go.example.com", "/x/y"},
+		{"example.com", "/x/v2/%2e%2e/v2"},
+		{"example.com", "/a b"},
+		{"example.com", "/a.v2/b"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+
+	f.Fuzz(func(t *testing.T, host, urlPath string) {
+		host = normalizeHost(host)
+		path, err := canonicalImportPath(host, urlPath)
+		if err != nil {
+			return
+		}
+
+		again, err := canonicalImportPath(host, canonicalURLPath(host, path))
+		if err != nil || again != path {
+			t.Fatalf("canonical path %q is not stable: got %q, %v", path, again, err)
+		}
+
+		pkgConf := conf.FindPackage(path)
+		if pkgConf.Prefix == "" {
+			return
+		}
+		if pkgConf.Prefix != path && !strings.HasPrefix(path, pkgConf.Prefix+"/") {
+			t.Fatalf("FindPackage(%q) matched prefix %q, which does not cover the path", path, pkgConf.Prefix)
+		}
+		for _, imp := range pkgConf.GoImports() {
+			if fields := strings.Fields(imp); len(fields) != 3 || fields[0] != pkgConf.Prefix {
+				t.Fatalf("FindPackage(%q) produced malformed go-import content %q", path, imp)
+			}
+		}
+	})
+}
+
+func TestDecodeSampleConfig(t *testing.T) {
+	f, err := os.Open("../../importbounce.sample.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := decodeConfig(f); err != nil {
+		t.Errorf("sample config is invalid: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		description string
+		toml        string
+		want        []string
+	}{
+		{
+			description: "valid",
+			toml: `
+				default_redirect = "https://example.com"
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://github.com/example/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+			`,
+		},
+		{
+			description: "unknown keys",
+			toml: `
+				default_redirct = "https://example.com"
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://github.com/example/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+				[[packages]]
+				prefix = "example.com/b"
+				import = "git https://github.com/example/b"
+				redirct = "https://pkg.go.dev/example.com/b"
+			`,
+			want: []string{
+				`default_redirct: unknown key`,
+				`packages[1].redirct: unknown key`,
+				`packages[1] (prefix "example.com/b"): missing redirect`,
+			},
+		},
+		{
+			description: "bad imports and redirects",
+			toml: `
+				default_redirect = "example.com"
+				[[packages]]
+				prefix = "example.com/a"
+				import = "https://github.com/example/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+				[[packages]]
+				prefix = "example.com/b"
+				import = "gti https://github.com/example/b"
+				redirect = "/b"
+				[[packages]]
+				prefix = "example.com/c"
+				import = "git github.com/example/c"
+				redirect = "https://pkg.go.dev/example.com/c"
+			`,
+			want: []string{
+				`default_redirect: "example.com" is not an absolute URL`,
+				`packages[0] (prefix "example.com/a"): import "https://github.com/example/a" must have the form "<vcs> <repo-root>"`,
+				`packages[1] (prefix "example.com/b"): import "gti https://github.com/example/b" has unknown VCS "gti" (want one of bzr, fossil, git, hg, mod, svn)`,
+				`packages[1] (prefix "example.com/b"): redirect: "/b" is not an absolute URL`,
+				`packages[2] (prefix "example.com/c"): import "git github.com/example/c" has invalid repo root: "github.com/example/c" is not an absolute URL`,
+			},
+		},
+		{
+			description: "duplicate prefixes",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://github.com/example/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+				[[packages]]
+				prefix = "example.com/a/"
+				import = "git https://github.com/example/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+				[[packages]]
+				prefix = "example.com/a/sub"
+				import = "git https://github.com/example/a-sub"
+				redirect = "https://pkg.go.dev/example.com/a/sub"
+			`,
+			want: []string{
+				`packages[1] (prefix "example.com/a/"): duplicate prefix`,
+			},
+		},
+		{
+			description: "patterns",
+			toml: `
+				[[packages]]
+				prefix = "example.com/{repo}"
+				import = "git https://github.com/example/{repo}"
+				redirect = "https://pkg.go.dev/example.com/{repo}{/rest}"
+				[[packages]]
+				prefix = "example.com/{name}"
+				import = "git https://github.com/example/{name}"
+				redirect = "https://pkg.go.dev/example.com/{name}"
+				[[packages]]
+				prefix = "{host}/x-{repo}/{rest}"
+				import = "git https://github.com/example/{rest}"
+				redirect = "https://pkg.go.dev/{other}"
+			`,
+			want: []string{
+				`packages[1] (prefix "example.com/{name}"): duplicate prefix`,
+				`packages[2] (prefix "{host}/x-{repo}/{rest}"): the first segment of the prefix must not be a placeholder`,
+				`packages[2] (prefix "{host}/x-{repo}/{rest}"): placeholders must make up an entire path segment`,
+				`packages[2] (prefix "{host}/x-{repo}/{rest}"): placeholder name "rest" is reserved or already used`,
+				`packages[2] (prefix "{host}/x-{repo}/{rest}"): import: unknown placeholder "{rest}"`,
+				`packages[2] (prefix "{host}/x-{repo}/{rest}"): redirect: unknown placeholder "{other}"`,
+			},
+		},
+		{
+			description: "unreachable patterns",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://github.com/example/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+				[[packages]]
+				prefix = "example.com/a/{sub}"
+				import = "git https://github.com/example/a-{sub}"
+				redirect = "https://pkg.go.dev/example.com/a/{sub}"
+				[[packages]]
+				prefix = "example.com/{repo}/b"
+				import = "git https://github.com/example/{repo}-b"
+				redirect = "https://pkg.go.dev/example.com/{repo}/b"
+				[[packages]]
+				prefix = "example.com/ab/{sub}"
+				import = "git https://github.com/example/ab-{sub}"
+				redirect = "https://pkg.go.dev/example.com/ab/{sub}"
+				[[packages]]
+				prefix = "example.org/a/{sub}"
+				import = "git https://github.com/example/a-{sub}"
+				redirect = "https://pkg.go.dev/example.org/a/{sub}"
+			`,
+			want: []string{
+				`packages[1] (prefix "example.com/a/{sub}"): pattern is unreachable, as explicit prefix "example.com/a" takes precedence`,
+			},
+		},
+		{
+			description: "request placeholders",
+			toml: `
+				default_redirect = "https://example.com/search?q={path}&from={repo}"
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://github.com/example/{path}"
+				redirect = "https://github.com/example/a/tree/main/{rest}{?query}"
+			`,
+			want: []string{
+				`default_redirect: unknown placeholder "{repo}"`,
+				`packages[0] (prefix "example.com/a"): import: unknown placeholder "{path}"`,
+			},
+		},
+		{
+			description: "hosts",
+			toml: `
+				[[packages]]
+				prefix = "go.example.com/a"
+				import = "git https://github.com/example/a"
+				redirect = "https://pkg.go.dev/{path}"
+				[hosts."go.example.com"]
+				aliases = ["www.go.example.com", "Go.Example.com"]
+				[[hosts."go.example.com".packages]]
+				prefix = "b"
+				github = "example/b"
+				[[hosts."go.example.com".packages]]
+				prefix = "b/"
+				github = "example/b"
+				[hosts."go.example.org:8080"]
+				default_redirect = "example.org"
+				[unknown_host]
+				redirect = "https://example.com/{repo}"
+			`,
+			want: []string{
+				`hosts."go.example.com": host "Go.Example.com" is already configured by hosts."go.example.com"`,
+				`hosts."go.example.com".packages[1] (prefix "go.example.com/b/"): duplicate prefix`,
+				`hosts."go.example.org:8080": "go.example.org:8080" must be a host name without a scheme, port or path, like "go.example.com"`,
+				`hosts."go.example.org:8080": default_redirect: "example.org" is not an absolute URL`,
+				`packages[0] (prefix "go.example.com/a"): host "go.example.com" is configured by hosts."go.example.com", so this package is never used`,
+				`unknown_host: redirect: unknown placeholder "{repo}"`,
+			},
+		},
+		{
+			description: "server",
+			toml: `
+				[server]
+				allowed_hosts = ["go.example.com", "https://go.example.org"]
+				rejected_host_status = 403
+				[[server.trusted_proxies]]
+				from = ["10.0.0.0/8", "localhost"]
+				header = "X-Real-Host"
+				[[server.trusted_proxies]]
+				header = "forwarded"
+			`,
+			want: []string{
+				`server: allowed_hosts: "https://go.example.org" must be a host name without a scheme, port or path, like "go.example.com"`,
+				`server: rejected_host_status must be 421 or 404`,
+				`server.trusted_proxies[0]: from: "localhost" is not an IP address or CIDR range`,
+				`server.trusted_proxies[0]: header must be one of Forwarded, X-Forwarded-Host`,
+				`server.trusted_proxies[1]: missing from`,
+			},
+		},
+		{
+			description: "module proxy",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				import = "mod self"
+				redirect = "https://pkg.go.dev/example.com/a"
+				[[packages]]
+				prefix = "example.com/b"
+				import = "git https://github.com/acme/b"
+				redirect = "https://pkg.go.dev/example.com/b"
+				versions.v2 = { import = "mod self" }
+				[[packages]]
+				prefix = "example.com/c"
+				import = "git self"
+				redirect = "https://pkg.go.dev/example.com/c"
+			`,
+			want: []string{
+				`packages[2] (prefix "example.com/c"): import "git self" has invalid repo root: "self" is not an absolute URL`,
+				`packages[0] (prefix "example.com/a"): import "mod self" requires a [proxy] section`,
+				`packages[1] (prefix "example.com/b"): import "mod self" requires a [proxy] section`,
+			},
+		},
+		{
+			description: "module proxy source",
+			toml: `
+				[proxy]
+				source = "ftp://modules.example.com"
+				[[packages]]
+				prefix = "example.com/a"
+				import = "mod self"
+				redirect = "https://pkg.go.dev/example.com/a"
+			`,
+			want: []string{
+				`proxy: source has unknown URL scheme "ftp"`,
+			},
+		},
+		{
+			description: "version selectors",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://github.com/acme/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+				version_selector = "path"
+				[[packages]]
+				prefix = "example.com/b"
+				import = "git https://github.com/acme/b"
+				redirect = "https://pkg.go.dev/example.com/b"
+				version_selector = "dot"
+				[[packages]]
+				prefix = "example.com/c"
+				import = ["mod https://proxy.example.com", "git https://github.com/acme/c"]
+				redirect = "https://pkg.go.dev/example.com/c"
+				version_selector = "suffix"
+				[[packages]]
+				prefix = "example.com/d"
+				import = "hg https://hg.example.com/d"
+				redirect = "https://pkg.go.dev/example.com/d"
+				version_selector = "suffix"
+				[[packages]]
+				prefix = "example.org"
+				import = "git ssh://git.example.org/root"
+				redirect = "https://pkg.go.dev/example.org"
+				version_selector = "suffix"
+			`,
+			want: []string{
+				`packages[1] (prefix "example.com/b"): version_selector must be "suffix" or "path"`,
+				`packages[2] (prefix "example.com/c"): version_selector is not supported with a "mod" import`,
+				`packages[3] (prefix "example.com/d"): version_selector requires a git import`,
+				`packages[4] (prefix "example.org"): version_selector "suffix" requires a prefix with a path after the host`,
+				`packages[4] (prefix "example.org"): version_selector requires a git repo root with an http or https URL`,
+			},
+		},
+		{
+			description: "versions",
+			toml: `
+				[[packages]]
+				prefix = "example.com/lib"
+				import = "mod https://proxy.example.com"
+				redirect = "https://pkg.go.dev/example.com/lib{/major}{/rest}"
+				[packages.versions.v1]
+				import = "git https://github.com/acme/lib"
+				[packages.versions.v2]
+				import = "git https://github.com/acme/lib-v2"
+				subdir = "/v2"
+				[packages.versions.v3]
+				subdir = "v3"
+				redirect = "https://example.com/{rest}/{other}"
+			`,
+			want: []string{
+				`packages[0] (prefix "example.com/lib"): versions.v1: "v1" is not a major version suffix like "v2"`,
+				`packages[0] (prefix "example.com/lib"): versions.v2: subdir "/v2" must be a clean, relative, slash-separated path within the repository`,
+				`packages[0] (prefix "example.com/lib"): versions.v3: subdir is not supported with the mod VCS`,
+				`packages[0] (prefix "example.com/lib"): versions.v3: redirect: unknown placeholder "{other}"`,
+			},
+		},
+		{
+			description: "statuses",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				github = "example/a"
+				status = "archived"
+				[[packages]]
+				prefix = "example.com/b"
+				github = "example/b"
+				message = "Use example.com/c."
+				[[packages]]
+				prefix = "example.com/{repo}"
+				github = "example/{repo}"
+				status = "moved"
+				[[packages]]
+				prefix = "example.com/{repo}/legacy"
+				github = "example/{repo}"
+				status = "deprecated"
+				successor = "example.com/{name}"
+				[[packages]]
+				prefix = "example.com/gone"
+				status = "retired"
+				successor = "https://example.com/new"
+			`,
+			want: []string{
+				`packages[0] (prefix "example.com/a"): status "archived" must be one of deprecated, moved, retired`,
+				`packages[1] (prefix "example.com/b"): message and successor require a status`,
+				`packages[2] (prefix "example.com/{repo}"): a moved package must have a successor`,
+				`packages[3] (prefix "example.com/{repo}/legacy"): successor: unknown placeholder "{name}"`,
+				`packages[4] (prefix "example.com/gone"): successor: malformed import path "https://example.com/new": double slash`,
+			},
+		},
+		{
+			description: "caching",
+			toml: `
+				redirect_status = 303
+				[cache]
+				go_get = { max_age = "-1h" }
+				[[packages]]
+				prefix = "example.com/a"
+				github = "example/a"
+				redirect_status = 200
+				cache.redirect = { max_age = "1h", cache_control = "no-cache" }
+				[hosts."go.example.com"]
+				cache.not_found = { stale_while_revalidate = "-1s" }
+			`,
+			want: []string{
+				`redirect_status: redirect_status must be 301, 302, 307 or 308`,
+				`cache: go_get: durations must not be negative`,
+				`packages[0] (prefix "example.com/a"): redirect_status must be 301, 302, 307 or 308`,
+				`packages[0] (prefix "example.com/a"): cache.redirect: cache_control can't be combined with max_age or stale_while_revalidate`,
+				`hosts."go.example.com": cache.not_found: durations must not be negative`,
+			},
+		},
+		{
+			description: "index page templates",
+			toml: `
+				index_page_template = "{{.Hots}}"
+				[hosts."go.example.com"]
+				index_page = true
+				index_page_template = "{{range .Packages}}"
+			`,
+			want: []string{
+				`index_page_template: template: index_page_template:1:2: executing "index_page_template" at <.Hots>: can't evaluate field Hots in type bouncer.indexPage`,
+				`hosts."go.example.com": index_page_template: template: index_page_template:1: unexpected EOF`,
+			},
+		},
+		{
+			description: "subdirs",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://github.com/example/mono"
+				subdir = "tools/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+				[[packages]]
+				prefix = "example.com/b"
+				import = "git https://github.com/example/mono"
+				subdir = "/tools/../b"
+				redirect = "https://pkg.go.dev/example.com/b"
+				[[packages]]
+				prefix = "example.com/c"
+				import = "mod https://proxy.example.com"
+				subdir = "c"
+				redirect = "https://pkg.go.dev/example.com/c"
+			`,
+			want: []string{
+				`packages[1] (prefix "example.com/b"): subdir "/tools/../b" must be a clean, relative, slash-separated path within the repository`,
+				`packages[2] (prefix "example.com/c"): subdir is not supported with the mod VCS`,
+			},
+		},
+		{
+			description: "sources",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				import = "git https://git.example.com/a"
+				redirect = "https://pkg.go.dev/example.com/a"
+				source = { home = "https://git.example.com/a" }
+				[[packages]]
+				prefix = "example.com/b"
+				import = "git https://git.example.com/b"
+				redirect = "https://pkg.go.dev/example.com/b"
+				source = { forge = "gittea" }
+				[[packages]]
+				prefix = "example.com/{dir}"
+				import = "git https://github.com/example/{dir}"
+				redirect = "https://pkg.go.dev/example.com/{dir}"
+				source = { file = "{dir}/{file}" }
+			`,
+			want: []string{
+				`packages[0] (prefix "example.com/a"): source: home, directory and file must all be set, unless the repository is on a known forge or source.forge is set`,
+				`packages[1] (prefix "example.com/b"): source: unknown forge "gittea" (want one of bitbucket, gitea, github, gitlab, sourcehut)`,
+				`packages[2] (prefix "example.com/{dir}"): placeholder name "dir" is reserved or already used`,
+				`packages[2] (prefix "example.com/{dir}"): import: unknown placeholder "{dir}"`,
+				`packages[2] (prefix "example.com/{dir}"): source file: "x/x" is not an absolute URL`,
+				`packages[2] (prefix "example.com/{dir}"): redirect: unknown placeholder "{dir}"`,
+			},
+		},
+		{
+			description: "import lists",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				import = ["mod https://proxy.example.com", "git https://github.com/example/a"]
+				redirect = "https://pkg.go.dev/example.com/a"
+				[[packages]]
+				prefix = "example.com/b"
+				import = ["git https://github.com/example/b", "hg https://hg.example.com/b"]
+				redirect = "https://pkg.go.dev/example.com/b"
+				[[packages]]
+				prefix = "example.com/c"
+				import = []
+				redirect = "https://pkg.go.dev/example.com/c"
+			`,
+			want: []string{
+				`packages[1] (prefix "example.com/b"): import must have at most one "mod" entry and one entry for another VCS`,
+				`packages[2] (prefix "example.com/c"): missing import`,
+			},
+		},
+		{
+			description: "forge shorthands",
+			toml: `
+				[[packages]]
+				prefix = "example.com/a"
+				github = "acme/a"
+				gitlab = "acme/a"
+				[[packages]]
+				prefix = "example.com/b"
+				codeberg = "https://codeberg.org/acme/b"
+				import = "git https://codeberg.org/acme/b"
+			`,
+			want: []string{
+				`packages[0] (prefix "example.com/a"): only one of github, gitlab, codeberg, sourcehut, bitbucket can be set`,
+				`packages[0] (prefix "example.com/a"): missing import`,
+				`packages[0] (prefix "example.com/a"): missing redirect`,
+				`packages[1] (prefix "example.com/b"): codeberg = "https://codeberg.org/acme/b" must have the form "owner/repo"`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := decodeConfig(strings.NewReader(tc.toml))
+
+			var got []string
+			var errs configErrors
+			if errors.As(err, &errs) {
+				for _, err := range errs {
+					got = append(got, err.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wrong errors\ngot:  %q\nwant: %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package bouncer
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Export renders the config file at configURL (see New for supported schemes)
+// as a static site in dir, for hosting vanity import paths without a running
+// Bouncer, and writes a report of each file written or package skipped to w.
+//
+// Each host, including each alias of a host, gets a directory in dir. Every
+// package on the host gets an index.html file at the path of its prefix with
+// the response that a Bouncer serves to the go command, whose meta refresh
+// sends web browsers to the package's redirect. The root of the host gets the
+// index page for the host, unless a package is served there, and a 404.html
+// page for paths that aren't packages.
+//
+// Packages with placeholders in their prefix can't be listed ahead of time,
+// retired packages have nothing to serve, and a static site can't serve the
+// built-in module proxy, so none of these packages are exported.
+func Export(ctx context.Context, configURL, dir string, w io.Writer) error {
+	b, c, err := loadFixed(ctx, configURL)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range c.exportHosts() {
+		if !c.Server.allowsHost(host) {
+			fmt.Fprintf(w, "%s: skipped, as it is not in server.allowed_hosts\n", host)
+			continue
+		}
+		if err := b.exportHost(ctx, &c, host, dir, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportHosts returns the names of every host that the config serves packages
+// on, including aliases, in sorted order.
+func (c *config) exportHosts() []string {
+	var hosts []string
+	for _, p := range c.Packages {
+		if host, _, _ := strings.Cut(p.Prefix, "/"); !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	for _, h := range c.Hosts {
+		hosts = append(hosts, h.name)
+		for _, alias := range h.Aliases {
+			hosts = append(hosts, strings.ToLower(alias))
+		}
+	}
+	slices.Sort(hosts)
+	return hosts
+}
+
+// exportHost writes the files for a single host.
+func (b *Bouncer) exportHost(ctx context.Context, c *config, host, dir string, w io.Writer) error {
+	h := c.lookupHost(host)
+	var paths []string
+	for _, p := range h.packagesOn(host) {
+		switch {
+		case p.isPattern():
+			fmt.Fprintf(w, "%s: skipped prefix %q, which has placeholders\n", host, p.Prefix)
+			continue
+		case p.Status == statusRetired:
+			fmt.Fprintf(w, "%s: skipped prefix %q, which is retired\n", host, p.Prefix)
+			continue
+		case len(p.selfProxied()) > 0:
+			fmt.Fprintf(w, "%s: skipped prefix %q, which uses the built-in module proxy\n", host, p.Prefix)
+			continue
+		}
+		// Requests for an alias serve the host's packages under the alias.
+		prefix := strings.TrimSuffix(host+strings.TrimPrefix(p.Prefix, cmp.Or(h.name, host)), "/")
+		paths = append(paths, prefix)
+		for _, major := range p.majors() {
+			paths = append(paths, prefix+"/"+major)
+		}
+	}
+
+	for _, path := range paths {
+		body, err := b.exportPackage(ctx, path)
+		if err != nil {
+			return err
+		}
+		if err := writeExportFile(dir, path+"/index.html", body, w); err != nil {
+			return err
+		}
+	}
+
+	if !slices.Contains(paths, host) {
+		var page bytes.Buffer
+		if err := c.indexTemplate(h).Execute(&page, h.indexPage(host)); err != nil {
+			return fmt.Errorf("rendering index page for %s: %w", host, err)
+		}
+		if err := writeExportFile(dir, host+"/index.html", page.Bytes(), w); err != nil {
+			return err
+		}
+	}
+
+	var notFound bytes.Buffer
+	if err := notFoundTmpl.Execute(&notFound, c.exportNotFound(h, host)); err != nil {
+		return fmt.Errorf("rendering 404 page for %s: %w", host, err)
+	}
+	return writeExportFile(dir, host+"/404.html", notFound.Bytes(), w)
+}
+
+// exportPackage returns the response that a Bouncer serves to the go command
+// for the package at path.
+func (b *Bouncer) exportPackage(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, simulatedURL(path, true), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid import path %q: %w", path, err)
+	}
+	rec := httptest.NewRecorder()
+	b.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return nil, fmt.Errorf("serving %s: unexpected status %d", path, rec.Code)
+	}
+	return rec.Body.Bytes(), nil
+}
+
+// writeExportFile writes a file at the slash-separated name within dir,
+// creating any parent directories.
+func writeExportFile(dir, name string, data []byte, w io.Writer) error {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "wrote %s\n", name)
+	return nil
+}
+
+// exportNotFound returns the data for the 404 page of a host: the not-found
+// message, and the default redirect as a request for the root of the host
+// would receive it.
+func (c *config) exportNotFound(h *hostConfig, host string) notFoundPage {
+	host = cmp.Or(h.name, host)
+	return notFoundPage{
+		Message: cmp.Or(h.NotFound, "Package not found\n"),
+		Redirect: expandPlaceholders(cmp.Or(h.DefaultRedirect, c.DefaultRedirect), map[string]string{
+			pathVar:  host,
+			hostVar:  host,
+			restVar:  "",
+			queryVar: "",
+		}),
+	}
+}
+
+// notFoundPage is the data for notFoundTmpl.
+type notFoundPage struct {
+	Message  string
+	Redirect string
+}
+
+var notFoundTmpl = template.Must(template.New("").Parse(`<html>
+<head>
+{{- with .Redirect}}
+<meta http-equiv="refresh" content="0; url={{.}}">
+{{- end}}
+</head>
+<body>{{.Message}}</body>
+</html>
+`))
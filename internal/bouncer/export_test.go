@@ -0,0 +1,104 @@
+package bouncer
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	const configTOML = `
+default_redirect = "https://example.com/search?q={path}"
+
+[[packages]]
+prefix = "example.com/lib"
+github = "acme/lib"
+versions.v2 = { import = "git https://github.com/acme/lib2" }
+
+[[packages]]
+prefix = "example.com/{repo}"
+github = "acme/{repo}"
+
+[[packages]]
+prefix = "example.com/old"
+status = "retired"
+
+[hosts."go.example.com"]
+aliases = ["www.go.example.com"]
+not_found = "Nothing here.\n"
+
+[[hosts."go.example.com".packages]]
+prefix = "/"
+github = "acme/root"
+`
+
+	configPath := filepath.Join(t.TempDir(), "importbounce.toml")
+	if err := os.WriteFile(configPath, []byte(configTOML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	var report strings.Builder
+	if err := Export(context.Background(), "file://"+configPath, dir, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	wantFiles := []string{
+		"example.com/404.html",
+		"example.com/index.html",
+		"example.com/lib/index.html",
+		"example.com/lib/v2/index.html",
+		"go.example.com/404.html",
+		"go.example.com/index.html",
+		"www.go.example.com/404.html",
+		"www.go.example.com/index.html",
+	}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("wrong files\ngot:  %v\nwant: %v", files, wantFiles)
+	}
+	for _, skipped := range []string{`skipped prefix "example.com/{repo}"`, `skipped prefix "example.com/old"`} {
+		if !strings.Contains(report.String(), skipped) {
+			t.Errorf("report does not contain %q:\n%s", skipped, report.String())
+		}
+	}
+
+	// Package pages must match what a running Bouncer serves to the go
+	// command, byte for byte.
+	b := testBouncer(configTOML)
+	for _, path := range []string{"example.com/lib", "example.com/lib/v2", "go.example.com", "www.go.example.com"} {
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, httptest.NewRequest(http.MethodGet, simulatedURL(path, true), nil))
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path), "index.html"))
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != w.Body.String() {
+			t.Errorf("%s: exported page differs from served page\ngot:  %s\nwant: %s", path, got, w.Body.String())
+		}
+	}
+
+	for path, want := range map[string]string{
+		"example.com/index.html":  `<a href="https://pkg.go.dev/example.com/lib">example.com/lib</a>`,
+		"example.com/404.html":    `<meta http-equiv="refresh" content="0; url=https://example.com/search?q=example.com">`,
+		"go.example.com/404.html": "<body>Nothing here.\n</body>",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			t.Error(err)
+		} else if !strings.Contains(string(got), want) {
+			t.Errorf("%s does not contain %q:\n%s", path, want, got)
+		}
+	}
+}
@@ -5,21 +5,41 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	xrayawsv2 "github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
 )
 
 // fetcherFunc is a type for functions that can load TOML configuration files
 // for a Bouncer.
-type fetcherFunc func(context.Context) (io.ReadCloser, error)
+//
+// If prev identifies a version of the config that the caller already has, the
+// function may ask the source to skip the transfer when that version is still
+// current. In that case, it returns errNotModified.
+type fetcherFunc func(ctx context.Context, prev configVersion) (io.ReadCloser, configVersion, error)
+
+// configVersion identifies a version of a config file for conditional requests.
+// The zero value matches no version.
+type configVersion struct {
+	ETag         string
+	LastModified string
+}
+
+// errNotModified indicates that a config source has not changed since the
+// version provided to a fetcherFunc.
+var errNotModified = errors.New("config not modified")
 
 func getFetcherFromURL(configURL string) (fetcherFunc, error) {
 	if configURL == "" {
@@ -35,10 +55,10 @@ func getFetcherFromURL(configURL string) (fetcherFunc, error) {
 	if !ok {
 		return nil, fmt.Errorf("unknown config URL scheme %q", u.Scheme)
 	}
-	return factory(u), nil
+	return factory(u)
 }
 
-var fetcherFactories = map[string]func(*url.URL) fetcherFunc{
+var fetcherFactories = map[string]func(*url.URL) (fetcherFunc, error){
 	"http":     getHTTPConfigFetcher,
 	"https":    getHTTPConfigFetcher,
 	"file":     getFileConfigFetcher,
@@ -46,55 +66,185 @@ var fetcherFactories = map[string]func(*url.URL) fetcherFunc{
 	"s3+nossl": getS3ConfigFetcher,
 }
 
-func getHTTPConfigFetcher(u *url.URL) fetcherFunc {
-	return func(ctx context.Context) (io.ReadCloser, error) {
+func getHTTPConfigFetcher(u *url.URL) (fetcherFunc, error) {
+	return getHTTPFetcher(u, http.DefaultClient), nil
+}
+
+// getHTTPFetcher is like getHTTPConfigFetcher, with requests made by client.
+func getHTTPFetcher(u *url.URL, client *http.Client) fetcherFunc {
+	return func(ctx context.Context, prev configVersion) (io.ReadCloser, configVersion, error) {
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 		if err != nil {
-			return nil, fmt.Errorf("fetching config: %w", err)
+			return nil, configVersion{}, fmt.Errorf("fetching config: %w", err)
+		}
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
 		}
 
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
-			return nil, fmt.Errorf("fetching config: %w", err)
+			return nil, configVersion{}, fmt.Errorf("fetching config: %w", err)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusNotModified:
+			resp.Body.Close()
+			return nil, configVersion{}, errNotModified
+		case resp.StatusCode < 200 || resp.StatusCode > 299:
+			resp.Body.Close()
+			return nil, configVersion{}, fmt.Errorf("fetching config: %w", httpStatusError{resp.StatusCode, resp.Status})
 		}
 
-		return resp.Body, nil
+		version := configVersion{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		if resp.ContentLength >= 0 {
+			return sizedBody{resp.Body, resp.ContentLength}, version, nil
+		}
+		return resp.Body, version, nil
 	}
 }
 
-func getFileConfigFetcher(u *url.URL) fetcherFunc {
-	return func(_ context.Context) (io.ReadCloser, error) {
+func getFileConfigFetcher(u *url.URL) (fetcherFunc, error) {
+	return func(_ context.Context, prev configVersion) (io.ReadCloser, configVersion, error) {
 		path := filepath.Join(u.Host, u.Path)
 		f, err := os.Open(path)
 		if err != nil {
-			return nil, fmt.Errorf("opening config: %w", err)
+			return nil, configVersion{}, fmt.Errorf("opening config: %w", err)
+		}
+
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, configVersion{}, fmt.Errorf("opening config: %w", err)
+		}
+
+		// There's no content hash readily available here, so the size and
+		// modification time will have to do.
+		version := configVersion{
+			ETag: fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+		}
+		if version == prev {
+			f.Close()
+			return nil, configVersion{}, errNotModified
 		}
-		return f, nil
+		return f, version, nil
+	}, nil
+}
+
+func getS3ConfigFetcher(u *url.URL) (fetcherFunc, error) {
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+
+	newClient := s3Client
+	if strings.HasSuffix(u.Scheme, "+nossl") {
+		newClient = s3ClientNoSSL
+	}
+	client, err := newClient()
+	if err != nil {
+		return nil, err
 	}
+
+	return func(ctx context.Context, prev configVersion) (io.ReadCloser, configVersion, error) {
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		if prev.ETag != "" {
+			input.IfNoneMatch = aws.String(prev.ETag)
+		}
+
+		output, err := client.GetObject(ctx, input)
+		if err != nil {
+			var respErr *awshttp.ResponseError
+			if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+				return nil, configVersion{}, errNotModified
+			}
+			return nil, configVersion{}, fmt.Errorf("fetching config: %w", err)
+		}
+
+		version := configVersion{ETag: aws.ToString(output.ETag)}
+		if output.ContentLength != nil {
+			return sizedBody{output.Body, *output.ContentLength}, version, nil
+		}
+		return output.Body, version, nil
+	}, nil
 }
 
-func getS3ConfigFetcher(u *url.URL) fetcherFunc {
-	input := &s3.GetObjectInput{
-		Bucket: aws.String(u.Host),
-		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+// streamingClient makes requests whose responses are passed on to a client as
+// they arrive, like module zips and Git packs, which can take much longer to
+// transfer than config files. Only the wait for the response headers is
+// bounded, unlike with http.DefaultClient.
+var streamingClient = &http.Client{
+	Transport: func() http.RoundTripper {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.ResponseHeaderTimeout = 30 * time.Second
+		return t
+	}(),
+}
+
+// sizedBody is a body from a fetcherFunc whose size is known before reading.
+type sizedBody struct {
+	io.ReadCloser
+	size int64
+}
+
+// bodySize returns the size of a body from a fetcherFunc, or -1 if it isn't
+// known before reading.
+func bodySize(body io.ReadCloser) int64 {
+	switch body := body.(type) {
+	case sizedBody:
+		return body.size
+	case *os.File:
+		if stat, err := body.Stat(); err == nil {
+			return stat.Size()
+		}
 	}
+	return -1
+}
 
+// The S3 clients for fetchers are created on first use and shared, since the
+// module proxy creates a fetcher for every file that it serves. An AWS config
+// that fails to load is reported to every fetcher that needs the client.
+var (
+	s3Client      = sync.OnceValues(func() (*s3.Client, error) { return newS3Client(false) })
+	s3ClientNoSSL = sync.OnceValues(func() (*s3.Client, error) { return newS3Client(true) })
+)
+
+func newS3Client(disableSSL bool) (*s3.Client, error) {
 	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
 	if err != nil {
-		panic(fmt.Errorf("loading AWS config: %w", err))
+		return nil, fmt.Errorf("loading AWS config: %w", err)
 	}
 	xrayawsv2.AWSV2Instrumentor(&cfg.APIOptions)
 
-	disableSSL := strings.HasSuffix(u.Scheme, "+nossl")
-	s3Client := s3.NewFromConfig(cfg, func(options *s3.Options) {
+	return s3.NewFromConfig(cfg, func(options *s3.Options) {
 		options.EndpointOptions.DisableHTTPS = disableSSL
-	})
+	}), nil
+}
 
-	return func(ctx context.Context) (io.ReadCloser, error) {
-		output, err := s3Client.GetObject(ctx, input)
-		if err != nil {
-			return nil, fmt.Errorf("fetching config: %w", err)
-		}
-		return output.Body, nil
-	}
+// httpStatusError is the error for an HTTP response with an unsuccessful
+// status. A 404 or 410 status is treated as fs.ErrNotExist.
+type httpStatusError struct {
+	code   int
+	status string
+}
+
+func (e httpStatusError) Error() string {
+	return "unexpected HTTP status " + e.status
+}
+
+func (e httpStatusError) Is(target error) bool {
+	return target == fs.ErrNotExist && (e.code == http.StatusNotFound || e.code == http.StatusGone)
+}
+
+// isNotExist reports whether an error from a fetcherFunc means that the source
+// has nothing at the requested location.
+func isNotExist(err error) bool {
+	var noSuchKey *s3types.NoSuchKey
+	return errors.Is(err, fs.ErrNotExist) || errors.As(err, &noSuchKey)
 }
@@ -0,0 +1,161 @@
+package bouncer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPFetcher(t *testing.T) {
+	const (
+		etag         = `"v1"`
+		lastModified = "Tue, 02 Jan 2024 03:04:05 GMT"
+	)
+	var conditional http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = http.Header{}
+		for _, name := range []string{"If-None-Match", "If-Modified-Since"} {
+			if v := r.Header.Get(name); v != "" {
+				conditional.Set(name, v)
+			}
+		}
+		switch {
+		case r.URL.Path == "/broken.toml":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		case r.Header.Get("If-None-Match") == etag:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Last-Modified", lastModified)
+			io.WriteString(w, testConfig)
+		}
+	}))
+	defer server.Close()
+
+	fetch := func(path string, prev configVersion) (string, configVersion, error) {
+		t.Helper()
+		u, err := url.Parse(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, version, err := getHTTPFetcher(u, http.DefaultClient)(context.Background(), prev)
+		if err != nil {
+			return "", version, err
+		}
+		defer r.Close()
+		body, err := io.ReadAll(r)
+		return string(body), version, err
+	}
+
+	body, version, err := fetch("/importbounce.toml", configVersion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != testConfig {
+		t.Errorf("got body %q; want %q", body, testConfig)
+	}
+	if want := (configVersion{ETag: etag, LastModified: lastModified}); version != want {
+		t.Errorf("got version %+v; want %+v", version, want)
+	}
+	if len(conditional) > 0 {
+		t.Errorf("first fetch sent conditional headers %v", conditional)
+	}
+
+	if _, _, err := fetch("/importbounce.toml", version); !errors.Is(err, errNotModified) {
+		t.Errorf("revalidation got error %v; want %v", err, errNotModified)
+	}
+	if got, want := conditional.Get("If-None-Match"), etag; got != want {
+		t.Errorf("revalidation sent If-None-Match %q; want %q", got, want)
+	}
+	if got, want := conditional.Get("If-Modified-Since"), lastModified; got != want {
+		t.Errorf("revalidation sent If-Modified-Since %q; want %q", got, want)
+	}
+
+	_, _, err = fetch("/broken.toml", configVersion{})
+	var statusErr httpStatusError
+	if !errors.As(err, &statusErr) || statusErr.code != http.StatusInternalServerError {
+		t.Errorf("fetch with status 500 got error %v; want an HTTP status error", err)
+	}
+}
+
+func TestFileFetcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "importbounce.toml")
+	if err := os.WriteFile(path, []byte(testConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fetcher, err := getFileConfigFetcher(&url.URL{Scheme: "file", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(prev configVersion) (configVersion, error) {
+		t.Helper()
+		r, version, err := fetcher(context.Background(), prev)
+		if err == nil {
+			r.Close()
+		}
+		return version, err
+	}
+
+	first, err := fetch(configVersion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ETag == "" {
+		t.Error("got no ETag for the file")
+	}
+	if _, err := fetch(first); !errors.Is(err, errNotModified) {
+		t.Errorf("unchanged file got error %v; want %v", err, errNotModified)
+	}
+
+	// A change of size alone is enough to change the ETag, even when the
+	// modification time stays the same.
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(testConfig+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Time{}, stat.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	second, err := fetch(first)
+	if err != nil {
+		t.Fatalf("resized file got error %v", err)
+	}
+	if second == first {
+		t.Errorf("resized file kept ETag %q", first.ETag)
+	}
+
+	// So is a change of modification time alone.
+	if err := os.Chtimes(path, time.Time{}, stat.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if third, err := fetch(second); err != nil {
+		t.Errorf("touched file got error %v", err)
+	} else if third == second {
+		t.Errorf("touched file kept ETag %q", second.ETag)
+	}
+}
+
+func TestS3SourceWithoutAWSConfig(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_PROFILE", "importbounce-missing")
+
+	_, err := decodeConfig(strings.NewReader(`
+		[proxy]
+		source = "s3+nossl://modules/"
+	`))
+	if err == nil || !strings.Contains(err.Error(), "loading AWS config") {
+		t.Errorf("got error %v; want one for the AWS config", err)
+	}
+}
@@ -0,0 +1,289 @@
+package bouncer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	modzip "golang.org/x/mod/zip"
+)
+
+// gitModule generates the files of a module for the built-in module proxy from
+// the tags of a Git repository on the local filesystem. Each semver tag, under
+// the tag prefix for the module's subdirectory, is a version of the module.
+type gitModule struct {
+	path      string // the module path
+	pathMajor string // the major version suffix of the path, like "/v2"
+	repo      string // the directory of the repository, which may be bare
+	subdir    string // the directory of the module within the repository
+	cacheDir  string // the directory for generated files
+}
+
+// gitModule returns the generator for the module of a package that has a local
+// repository, whose settings are resolved for the module path.
+func (pc *proxyConfig) gitModule(p packageConfig) *gitModule {
+	_, pathMajor, _ := module.SplitPathVersion(p.Prefix)
+	return &gitModule{
+		path:      p.Prefix,
+		pathMajor: pathMajor,
+		repo:      p.LocalRepo,
+		subdir:    p.Subdir,
+		cacheDir:  pc.cacheDir(),
+	}
+}
+
+// cacheDir returns the directory in which modules generated from local
+// repositories are cached.
+func (pc *proxyConfig) cacheDir() string {
+	if pc.CacheDir != "" {
+		return pc.CacheDir
+	}
+	return filepath.Join(os.TempDir(), "importbounce-modules")
+}
+
+// tagPrefix returns the prefix of the module's version tags. Following the go
+// command, this is the module's subdirectory, except for a final path element
+// that only repeats the major version of the module path.
+func (m *gitModule) tagPrefix() string {
+	dir := m.subdir
+	if m.pathMajor != "" && path.Base(dir) == strings.TrimPrefix(m.pathMajor, "/") {
+		dir = path.Dir(dir)
+	}
+	if dir == "" || dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// open opens a file of the module by its name in the GOPROXY protocol, like
+// "@v/list" or "@v/v1.0.0.zip". The files for each version are generated on
+// first use and cached, as the tags for a version must never change. Cached
+// files are served without looking at the repository at all.
+func (m *gitModule) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if name == "@v/list" {
+		versions, err := m.versions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var list bytes.Buffer
+		for _, v := range versions {
+			fmt.Fprintln(&list, v)
+		}
+		return io.NopCloser(&list), nil
+	}
+
+	file, ok := strings.CutPrefix(name, "@v/")
+	if !ok {
+		return nil, fs.ErrNotExist // like "@latest", which comes from the list
+	}
+	ext := path.Ext(file)
+	version, err := module.UnescapeVersion(strings.TrimSuffix(file, ext))
+	if err != nil || semver.Canonical(version) != version {
+		return nil, fs.ErrNotExist
+	}
+
+	escapedPath, err := module.EscapePath(m.path)
+	if err != nil {
+		return nil, err
+	}
+	cached := filepath.Join(m.cacheDir, filepath.FromSlash(escapedPath), "@v", file)
+	if f, err := os.Open(cached); err == nil {
+		return f, nil
+	}
+
+	versions, err := m.versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(versions, version) {
+		return nil, fs.ErrNotExist
+	}
+
+	var data bytes.Buffer
+	rev := m.tagPrefix() + version
+	switch ext {
+	case ".info":
+		err = m.writeInfo(ctx, &data, version, rev)
+	case ".mod":
+		err = m.writeGoMod(ctx, &data, rev)
+	case ".zip":
+		err = m.writeZip(ctx, &data, version, rev)
+	default:
+		return nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generating %s for %s@%s: %w", ext, m.path, version, err)
+	}
+	if err := writeCacheFile(cached, data.Bytes()); err != nil {
+		return nil, fmt.Errorf("caching %s for %s@%s: %w", ext, m.path, version, err)
+	}
+	return sizedBody{io.NopCloser(&data), int64(data.Len())}, nil
+}
+
+// versions returns the versions of the module in semver order: the canonical
+// semver tags under the tag prefix that are valid for the major version of the
+// module path.
+func (m *gitModule) versions(ctx context.Context) ([]string, error) {
+	out, err := m.git(ctx, "tag", "--list", m.tagPrefix()+"v*")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, tag := range strings.Fields(string(out)) {
+		v := strings.TrimPrefix(tag, m.tagPrefix())
+		if semver.Canonical(v) == v && module.CheckPathMajor(v, m.pathMajor) == nil {
+			versions = append(versions, v)
+		}
+	}
+	semver.Sort(versions)
+	return versions, nil
+}
+
+// writeInfo writes the info for a version, with the time of its commit.
+func (m *gitModule) writeInfo(ctx context.Context, w io.Writer, version, rev string) error {
+	out, err := m.git(ctx, "show", "--no-patch", "--format=%ct", rev+"^{commit}")
+	if err != nil {
+		return err
+	}
+	unix, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing commit time: %w", err)
+	}
+	return json.NewEncoder(w).Encode(struct {
+		Version string
+		Time    time.Time
+	}{version, time.Unix(unix, 0).UTC()})
+}
+
+// writeGoMod writes the go.mod file for a version, or a minimal one naming the
+// module if the version doesn't have one.
+func (m *gitModule) writeGoMod(ctx context.Context, w io.Writer, rev string) error {
+	name := path.Join(m.subdir, "go.mod")
+	if out, err := m.git(ctx, "ls-tree", "--name-only", rev, "--", name); err != nil {
+		return err
+	} else if len(out) == 0 {
+		_, err := fmt.Fprintf(w, "module %s\n", m.path)
+		return err
+	}
+	out, err := m.git(ctx, "cat-file", "blob", rev+":"+name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// writeZip writes the module zip for a version, following the same rules for
+// the files it contains as the go command. A module in a subdirectory without
+// its own LICENSE file gets the one at the root of the repository, if any.
+func (m *gitModule) writeZip(ctx context.Context, w io.Writer, version, rev string) error {
+	// As in the go command, line endings are left as they are in the
+	// repository, whatever the platform.
+	args := []string{"-c", "core.autocrlf=input", "-c", "core.eol=lf", "archive", "--format=zip", rev}
+	var prefix string
+	if m.subdir != "" {
+		prefix = m.subdir + "/"
+		args = append(args, "--", m.subdir)
+		if out, err := m.git(ctx, "ls-tree", "--name-only", rev, "--", "LICENSE"); err != nil {
+			return err
+		} else if len(out) > 0 {
+			args = append(args, "LICENSE")
+		}
+	}
+	out, err := m.git(ctx, args...)
+	if err != nil {
+		return err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		return fmt.Errorf("reading git archive: %w", err)
+	}
+
+	var (
+		files       []modzip.File
+		haveLicense bool
+		rootLicense *zip.File
+	)
+	for _, f := range archive.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if name, ok := strings.CutPrefix(f.Name, prefix); ok {
+			files = append(files, archiveFile{name, f})
+			haveLicense = haveLicense || name == "LICENSE"
+		} else if f.Name == "LICENSE" {
+			rootLicense = f
+		}
+	}
+	if !haveLicense && rootLicense != nil {
+		files = append(files, archiveFile{"LICENSE", rootLicense})
+	}
+	return modzip.Create(w, module.Version{Path: m.path, Version: version}, files)
+}
+
+// archiveFile is a file from a git archive, as a file for a module zip.
+type archiveFile struct {
+	path string
+	f    *zip.File
+}
+
+func (f archiveFile) Path() string                 { return f.path }
+func (f archiveFile) Lstat() (fs.FileInfo, error)  { return f.f.FileInfo(), nil }
+func (f archiveFile) Open() (io.ReadCloser, error) { return f.f.Open() }
+
+// git runs a Git command in the repository and returns its output.
+func (m *gitModule) git(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = m.repo
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", gitSubcommand(args), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitSubcommand returns the name of the subcommand in the arguments to a Git
+// command, after any "-c name=value" options.
+func gitSubcommand(args []string) string {
+	for len(args) > 2 && args[0] == "-c" {
+		args = args[2:]
+	}
+	return args[0]
+}
+
+// writeCacheFile writes a file to the cache, replacing it atomically so that
+// concurrent readers never see part of it.
+func writeCacheFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
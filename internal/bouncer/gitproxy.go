@@ -0,0 +1,241 @@
+package bouncer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The endpoints of the Git smart HTTP protocol for fetching from a repository,
+// relative to the repository's URL.
+const (
+	gitInfoRefs    = "info/refs"
+	gitUploadPack  = "git-upload-pack"
+	gitServiceLine = "# service=git-upload-pack\n"
+)
+
+// gitRequest reports whether a request is for the Git smart HTTP protocol, as
+// the go command makes when it clones the repository of a package with a
+// version selector, and returns the URL path of the repository and the
+// endpoint within it.
+func gitRequest(r *http.Request) (repoPath, endpoint string, ok bool) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("service") == gitUploadPack:
+		repoPath, ok = strings.CutSuffix(r.URL.Path, "/"+gitInfoRefs)
+		return repoPath, gitInfoRefs, ok
+	case r.Method == http.MethodPost:
+		repoPath, ok = strings.CutSuffix(r.URL.Path, "/"+gitUploadPack)
+		return repoPath, gitUploadPack, ok
+	default:
+		return "", "", false
+	}
+}
+
+// serveGit serves a Git smart HTTP protocol request for the repository of a
+// package with a version selector, by proxying it to the package's Git
+// repository. Only the ref advertisement differs from the upstream one, with
+// HEAD moved to the ref that the version selector selects.
+func (c *config) serveGit(w http.ResponseWriter, r *http.Request, host, repoPath, endpoint string) {
+	path, err := canonicalImportPath(host, repoPath)
+	if err != nil || canonicalURLPath(host, path) != repoPath {
+		http.NotFound(w, r)
+		return
+	}
+	hostConf := c.lookupHost(host)
+	if hostConf == nil {
+		http.NotFound(w, r)
+		return
+	}
+	pkgConf := hostConf.findPackage(path)
+	if pkgConf.selector == "" || pkgConf.Prefix != path || pkgConf.Status == statusRetired {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, root, _ := pkgConf.Import.repoRoot()
+	upstream := strings.TrimSuffix(root, "/") + "/" + endpoint
+	if endpoint == gitInfoRefs {
+		serveSelectedRefs(w, r, upstream, pkgConf.selector)
+	} else {
+		proxyUploadPack(w, r, upstream)
+	}
+}
+
+// serveSelectedRefs serves the ref advertisement from the info/refs endpoint
+// at upstream, with HEAD at the ref that selects the major version.
+func serveSelectedRefs(w http.ResponseWriter, r *http.Request, upstream, major string) {
+	refs, caps, err := fetchRefs(r, upstream)
+	switch {
+	case isNotExist(err):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		log.Printf("failed to fetch refs from %s: %v", upstream, err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	hash, name, ok := selectRef(refs, major)
+	if !ok {
+		http.Error(w, fmt.Sprintf("No branch or tag for %s\n", major), http.StatusNotFound)
+		return
+	}
+
+	// HEAD is only a symbolic ref when it's a branch; a detached HEAD at a
+	// tag is enough for the go command.
+	headCaps := make([]string, 0, len(caps)+1)
+	for _, c := range caps {
+		if !strings.HasPrefix(c, "symref=HEAD:") {
+			headCaps = append(headCaps, c)
+		}
+	}
+	if strings.HasPrefix(name, "refs/heads/") {
+		headCaps = append(headCaps, "symref=HEAD:"+name)
+	}
+
+	var adv bytes.Buffer
+	writePktLine(&adv, gitServiceLine)
+	adv.WriteString("0000")
+	writePktLine(&adv, hash+" HEAD\x00"+strings.Join(headCaps, " ")+"\n")
+	for _, ref := range refs {
+		if ref.name != "HEAD" {
+			writePktLine(&adv, ref.hash+" "+ref.name+"\n")
+		}
+	}
+	adv.WriteString("0000")
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(adv.Bytes())
+}
+
+// gitRef is a ref from a ref advertisement, with the name of a peeled tag
+// ending in "^{}".
+type gitRef struct {
+	hash, name string
+}
+
+// fetchRefs fetches and parses the ref advertisement from the info/refs
+// endpoint at upstream, returning the refs along with the capabilities that
+// the server advertised. The advertisement is always in protocol version 0.
+func fetchRefs(r *http.Request, upstream string) ([]gitRef, []string, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream+"?service="+gitUploadPack, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", r.Header.Get("User-Agent"))
+
+	resp, err := streamingClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, httpStatusError{resp.StatusCode, resp.Status}
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-git-upload-pack-advertisement" {
+		return nil, nil, fmt.Errorf("upstream is not a smart HTTP server (got Content-Type %q)", ct)
+	}
+
+	br := bufio.NewReader(resp.Body)
+	if line, err := readPktLine(br); err != nil || string(line) != gitServiceLine {
+		return nil, nil, fmt.Errorf("malformed ref advertisement: %q, %v", line, err)
+	}
+	if line, err := readPktLine(br); err != nil || line != nil {
+		return nil, nil, fmt.Errorf("malformed ref advertisement: %q, %v", line, err)
+	}
+
+	var (
+		refs []gitRef
+		caps []string
+	)
+	for first := true; ; first = false {
+		line, err := readPktLine(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading ref advertisement: %w", err)
+		}
+		if line == nil {
+			return refs, caps, nil
+		}
+
+		ref := strings.TrimSuffix(string(line), "\n")
+		if first {
+			var c string
+			ref, c, _ = strings.Cut(ref, "\x00")
+			caps = strings.Fields(c)
+		}
+		hash, name, ok := strings.Cut(ref, " ")
+		if !ok {
+			return nil, nil, fmt.Errorf("malformed ref %q", ref)
+		}
+		// An empty repository advertises its capabilities on a fake ref.
+		if name != "capabilities^{}" {
+			refs = append(refs, gitRef{hash, name})
+		}
+	}
+}
+
+// proxyUploadPack forwards a request to the git-upload-pack endpoint at
+// upstream, which serves the objects for the refs that the client wants, and
+// copies the response back to the client.
+func proxyUploadPack(w http.ResponseWriter, r *http.Request, upstream string) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, upstream, r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req.ContentLength = r.ContentLength
+	// The Git-Protocol header is left out, so that the upstream uses the same
+	// protocol version as the ref advertisement.
+	for _, name := range []string{"Content-Type", "Content-Encoding", "Accept", "User-Agent"} {
+		if v := r.Header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+
+	resp, err := streamingClient.Do(req)
+	if err != nil {
+		log.Printf("failed to proxy %s: %v", upstream, err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("failed to proxy %s: %v", upstream, err)
+	}
+}
+
+// readPktLine reads a line in Git's pkt-line format, returning nil for a flush
+// packet.
+func readPktLine(r *bufio.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseUint(string(length[:]), 16, 16)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("invalid pkt-line length %q", length[:])
+	case n == 0:
+		return nil, nil
+	case n < 4:
+		return nil, fmt.Errorf("unexpected special packet %q", length[:])
+	}
+	line := make([]byte, n-4)
+	_, err = io.ReadFull(r, line)
+	return line, err
+}
+
+// writePktLine writes a line in Git's pkt-line format.
+func writePktLine(w *bytes.Buffer, line string) {
+	fmt.Fprintf(w, "%04x%s", len(line)+4, line)
+}
@@ -0,0 +1,181 @@
+package bouncer
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// hostConfig holds the settings for the packages under a single host, from a
+// [hosts."name"] section of the config. The top-level settings of the config
+// form a hostConfig too, which serves any host without its own section.
+type hostConfig struct {
+	DefaultRedirect string          `toml:"default_redirect"`
+	NotFound        string          `toml:"not_found"`
+	Aliases         []string        `toml:"aliases"`
+	Packages        []packageConfig `toml:"packages"`
+	RedirectStatus  int             `toml:"redirect_status"`
+	Cache           cacheConfig     `toml:"cache"`
+
+	// IndexPage enables a page listing the host's packages at the root of
+	// the host, using IndexPageTemplate if it is set.
+	IndexPage         bool   `toml:"index_page"`
+	IndexPageTemplate string `toml:"index_page_template"`
+
+	name      string // the lowercase host name, or "" for the top-level settings
+	key       string // the key path of the packages, for reporting problems
+	index     *prefixIndex
+	indexTmpl *template.Template
+}
+
+// unknownHostConfig holds the settings for requests to hosts that the config
+// doesn't cover at all.
+type unknownHostConfig struct {
+	Redirect string `toml:"redirect"`
+	NotFound string `toml:"not_found"`
+}
+
+// expandHosts prepares each [hosts."name"] section for use, turning the
+// prefixes of its packages, which are relative to the host, into full prefixes.
+// The hosts of top-level prefixes are lowercased to match normalized requests.
+func (c *config) expandHosts() {
+	for i := range c.Packages {
+		p := &c.Packages[i]
+		if host, _, _ := strings.Cut(p.Prefix, "/"); !strings.Contains(host, "{") {
+			p.Prefix = strings.ToLower(host) + p.Prefix[len(host):]
+		}
+	}
+	for name, h := range c.Hosts {
+		h.name = strings.ToLower(name)
+		h.key = toml.Key{"hosts", name, "packages"}.String()
+		for i := range h.Packages {
+			p := &h.Packages[i]
+			if p.Prefix != "" {
+				p.Prefix = h.name + "/" + strings.TrimPrefix(p.Prefix, "/")
+			}
+		}
+	}
+}
+
+// hostNames returns the names of the [hosts."name"] sections in sorted order.
+func (c *config) hostNames() []string {
+	names := make([]string, 0, len(c.Hosts))
+	for name := range c.Hosts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// allPackages returns the top-level package list followed by the package list
+// of each host, sharing the storage of the config.
+func (c *config) allPackages() [][]packageConfig {
+	lists := [][]packageConfig{c.Packages}
+	for _, name := range c.hostNames() {
+		lists = append(lists, c.Hosts[name].Packages)
+	}
+	return lists
+}
+
+// lookupHost returns the settings that apply to requests for a host, which
+// must already be normalized with normalizeHost, or nil if the host is unknown.
+//
+// A host with its own section, or an alias of one, uses that section. Other
+// hosts use the top-level settings, unless the config has host sections or an
+// [unknown_host] section and none of the top-level packages are on the host.
+func (c *config) lookupHost(host string) *hostConfig {
+	if h, ok := c.hosts[host]; ok {
+		return h
+	}
+	if len(c.Hosts) == 0 && c.UnknownHost == nil {
+		return c.topLevel
+	}
+	if _, ok := c.topLevel.index.children[host]; ok {
+		return c.topLevel
+	}
+	return nil
+}
+
+// findPackage is like config.FindPackage, for a path on a host that uses these
+// settings. A request for an alias of the host is matched as if it were for
+// the host itself, but the prefix of the result is on the alias, as the go
+// command requires.
+func (h *hostConfig) findPackage(path string) packageConfig {
+	p, _ := h.matchPackage(path, "")
+	if selected, _ := h.findSelected(path); selected.Prefix != "" && len(splitPath(selected.Prefix)) >= len(splitPath(p.Prefix)) {
+		return selected
+	}
+	return p
+}
+
+// matchPackage returns the package that matches path, as requested with a
+// version selector for the given major version, or "" for none, along with
+// its index in h.Packages or -1 if no package matches. See findPackage.
+func (h *hostConfig) matchPackage(path, selector string) (packageConfig, int) {
+	canonical := h.canonicalPath(path)
+	i := h.index.match(canonical)
+	if i < 0 {
+		return packageConfig{}, -1
+	}
+
+	p := h.Packages[i]
+	p.selector = selector
+	p = p.resolve(canonical)
+	if canonical != path {
+		host, _, _ := strings.Cut(path, "/")
+		p.Prefix = host + strings.TrimPrefix(p.Prefix, h.name)
+	}
+	return p, i
+}
+
+// packagesOn returns the packages that these settings serve on host, which for
+// the top-level settings are only the packages whose prefix is on that host.
+func (h *hostConfig) packagesOn(host string) []packageConfig {
+	if h.name != "" {
+		return h.Packages
+	}
+	var pkgs []packageConfig
+	for _, p := range h.Packages {
+		if strings.HasPrefix(p.Prefix+"/", host+"/") {
+			pkgs = append(pkgs, p)
+		}
+	}
+	return pkgs
+}
+
+// canonicalPath replaces the host of path with the name of the host that these
+// settings are for, so that requests for aliases match the host's packages.
+func (h *hostConfig) canonicalPath(path string) string {
+	if h.name == "" {
+		return path
+	}
+	_, rest, _ := strings.Cut(path, "/")
+	return h.name + "/" + rest
+}
+
+// packageKey returns the key path of the package at index i, like
+// "packages[2]" or `hosts."go.example.com".packages[2]`.
+func (h *hostConfig) packageKey(i int) string {
+	return fmt.Sprintf("%s[%d]", h.key, i)
+}
+
+// normalizeHost returns the lowercase form of the host from an HTTP request,
+// without any port.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// checkHostName validates the name of a host section or alias.
+func checkHostName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/:@ ") {
+		return fmt.Errorf("%q must be a host name without a scheme, port or path, like \"go.example.com\"", name)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package bouncer
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// canonicalImportPath returns the import path that a request for the URL path
+// on a normalized host refers to, or an error if it isn't a valid import path.
+//
+// The URL path is cleaned of empty, "." and ".." segments and of any trailing
+// slash, and a ".git" suffix that a web visitor might copy from a clone URL is
+// removed from each segment. The URL path must already be decoded, as in
+// url.URL.Path, so percent-encoded characters are compared in decoded form.
+func canonicalImportPath(host, urlPath string) (string, error) {
+	cleaned := path.Clean("/" + urlPath)
+	segments := strings.Split(cleaned, "/")
+	for i := range segments {
+		segments[i] = strings.TrimSuffix(segments[i], ".git")
+	}
+
+	importPath := host + strings.TrimSuffix(strings.Join(segments, "/"), "/")
+	if err := module.CheckImportPath(importPath); err != nil {
+		return "", fmt.Errorf("invalid import path: %w", err)
+	}
+	return importPath, nil
+}
+
+// canonicalURLPath returns the URL path at which a canonical import path on
+// host is served.
+func canonicalURLPath(host, importPath string) string {
+	if p := strings.TrimPrefix(importPath, host); p != "" {
+		return p
+	}
+	return "/"
+}
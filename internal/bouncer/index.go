@@ -0,0 +1,128 @@
+package bouncer
+
+import "strings"
+
+// prefixIndex is a tree of package prefixes keyed by path segment, used to find
+// the package that matches an import path in time proportional to the length
+// of the path rather than the number of packages.
+type prefixIndex struct {
+	children map[string]*prefixIndex
+
+	// wildcard is the subtree for prefixes with a placeholder in this segment.
+	wildcard *prefixIndex
+
+	// pkg is 1 + the index in hostConfig.Packages of the package whose prefix ends
+	// at this node, or 0 if there is none.
+	pkg int
+}
+
+// buildIndex indexes the packages in the config, which must already be
+// validated to have unique prefixes within each host, and maps each host name
+// and alias to its settings.
+func (c *config) buildIndex() {
+	c.topLevel = &hostConfig{
+		DefaultRedirect:   c.DefaultRedirect,
+		Packages:          c.Packages,
+		IndexPage:         c.IndexPage,
+		IndexPageTemplate: c.IndexPageTemplate,
+		key:               "packages",
+	}
+	c.topLevel.buildIndex()
+
+	c.hosts = make(map[string]*hostConfig)
+	for _, h := range c.Hosts {
+		h.buildIndex()
+		c.hosts[h.name] = h
+		for _, alias := range h.Aliases {
+			c.hosts[strings.ToLower(alias)] = h
+		}
+	}
+}
+
+// buildIndex indexes the packages for the host, and parses its index page
+// template, which must already be validated.
+func (h *hostConfig) buildIndex() {
+	if h.IndexPageTemplate != "" {
+		h.indexTmpl, _ = parseIndexTemplate(h.IndexPageTemplate)
+	}
+
+	h.index = &prefixIndex{}
+	for i, pkgConf := range h.Packages {
+		node := h.index
+		for _, segment := range splitPath(pkgConf.Prefix) {
+			node = node.child(segment)
+		}
+		node.pkg = i + 1
+	}
+}
+
+// child returns the subtree for a prefix segment, creating it if necessary.
+func (idx *prefixIndex) child(segment string) *prefixIndex {
+	if _, ok := placeholderName(segment); ok {
+		if idx.wildcard == nil {
+			idx.wildcard = &prefixIndex{}
+		}
+		return idx.wildcard
+	}
+
+	child, ok := idx.children[segment]
+	if !ok {
+		child = &prefixIndex{}
+		if idx.children == nil {
+			idx.children = make(map[string]*prefixIndex)
+		}
+		idx.children[segment] = child
+	}
+	return child
+}
+
+// match returns the index in hostConfig.Packages of the package that matches path,
+// as described by config.FindPackage, or -1 if no package matches.
+func (idx *prefixIndex) match(path string) int {
+	segments := splitPath(path)
+
+	// Explicit prefixes can only be reached through literal segments. If one
+	// matches, it takes precedence over any pattern.
+	match := -1
+	node := idx
+	for _, segment := range segments {
+		node = node.children[segment]
+		if node == nil {
+			break
+		}
+		if node.pkg > 0 {
+			match = node.pkg - 1
+		}
+	}
+	if match >= 0 {
+		return match
+	}
+
+	match, _ = idx.longestPattern(segments, 0)
+	return match
+}
+
+// longestPattern returns the index in hostConfig.Packages of the package with the
+// longest prefix matching segments, along with the depth of its prefix. When
+// prefixes of the same length match, literal segments win over placeholders.
+func (idx *prefixIndex) longestPattern(segments []string, depth int) (match, matchDepth int) {
+	match, matchDepth = idx.pkg-1, depth
+	if len(segments) == 0 {
+		return match, matchDepth
+	}
+
+	for _, child := range []*prefixIndex{idx.children[segments[0]], idx.wildcard} {
+		if child == nil {
+			continue
+		}
+		if m, d := child.longestPattern(segments[1:], depth+1); m >= 0 && d > matchDepth {
+			match, matchDepth = m, d
+		}
+	}
+	return match, matchDepth
+}
+
+// splitPath splits an import path or package prefix into its segments.
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimSuffix(path, "/"), "/")
+}
@@ -0,0 +1,106 @@
+package bouncer
+
+import (
+	"cmp"
+	"html/template"
+	"io"
+	"slices"
+	"strings"
+)
+
+// indexPage is the data for the template of a host's index page, which lists
+// the packages served on the host.
+type indexPage struct {
+	Host     string
+	Packages []indexEntry
+}
+
+// indexEntry describes a single package on an index page. Packages with
+// placeholders in their prefix are listed with the placeholders in place, and
+// without a documentation link.
+type indexEntry struct {
+	ImportPath  string
+	Repo        string
+	Docs        string
+	Description string
+	Status      string
+}
+
+var defaultIndexTmpl = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Host}}</title>
+</head>
+<body>
+<h1>Go packages on {{.Host}}</h1>
+{{- if .Packages}}
+<table>
+<tr><th>Import path</th><th>Repository</th><th>Description</th></tr>
+{{- range .Packages}}
+<tr>
+<td>{{if .Docs}}<a href="{{.Docs}}">{{.ImportPath}}</a>{{else}}{{.ImportPath}}{{end}}{{with .Status}} ({{.}}){{end}}</td>
+<td>{{with .Repo}}{{.}}{{end}}</td>
+<td>{{.Description}}</td>
+</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>There are no packages here.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// parseIndexTemplate parses a custom index page template from the config, and
+// checks that it renders a sample page without error.
+func parseIndexTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("index_page_template").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := indexPage{
+		Host:     "go.example.com",
+		Packages: []indexEntry{{ImportPath: "go.example.com/sample"}},
+	}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// indexTemplate returns the template for the host's index page.
+func (c *config) indexTemplate(h *hostConfig) *template.Template {
+	return cmp.Or(h.indexTmpl, c.topLevel.indexTmpl, defaultIndexTmpl)
+}
+
+// indexPage returns the data for the index page of a host, which uses these
+// settings.
+func (h *hostConfig) indexPage(host string) indexPage {
+	page := indexPage{Host: cmp.Or(h.name, host)}
+	for _, p := range h.packagesOn(host) {
+		page.Packages = append(page.Packages, p.indexEntry())
+	}
+	slices.SortFunc(page.Packages, func(a, b indexEntry) int {
+		return strings.Compare(a.ImportPath, b.ImportPath)
+	})
+	return page
+}
+
+// indexEntry returns the entry for the package on an index page.
+func (p packageConfig) indexEntry() indexEntry {
+	entry := indexEntry{
+		ImportPath:  strings.TrimSuffix(p.Prefix, "/"),
+		Description: p.Description,
+		Status:      p.Status,
+	}
+	if !p.isPattern() {
+		p = p.resolve(p.Prefix)
+		if p.Status != statusRetired {
+			entry.Docs = expandPlaceholders(p.Redirect, map[string]string{queryVar: ""})
+		}
+	}
+	_, root, _ := p.Import.repoRoot()
+	entry.Repo = cmp.Or(root, p.moduleProxyURL())
+	return entry
+}
@@ -0,0 +1,251 @@
+package bouncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// loadedConfig is a config that was successfully fetched and decoded, along
+// with the version of the source it came from. loadedConfig values are never
+// modified once they are made current.
+type loadedConfig struct {
+	config  config
+	version configVersion
+
+	// loadedAt is the last time that the source confirmed this config was
+	// current.
+	loadedAt time.Time
+
+	// stale indicates that the most recent attempt to load a config failed,
+	// so this config is being served as a fallback.
+	stale bool
+
+	// failedAt is the time of the most recent failed attempt to load a config,
+	// if this config is stale.
+	failedAt time.Time
+}
+
+// minRetryInterval is the least time that a Bouncer waits after a failed load
+// before trying again for a request, so that a source that is down or serving
+// a broken config doesn't slow down every request served from the fallback.
+const minRetryInterval = 5 * time.Second
+
+// currentConfig returns the config to serve a request with, loading it first
+// unless the Bouncer is polling. If the config is a fallback for one that
+// failed to load, age is the time since it was last known to be current;
+// otherwise age is zero. An error is returned only if no config has ever
+// loaded successfully.
+func (b *Bouncer) currentConfig(ctx context.Context) (c config, age time.Duration, err error) {
+	var current *loadedConfig
+	if b.polling.Load() {
+		current = b.current.Load()
+		if current == nil {
+			return config{}, 0, errors.New("no config has been loaded yet")
+		}
+	} else {
+		current, err = b.reloadIfExpired(ctx)
+		if current == nil {
+			return config{}, 0, err
+		}
+	}
+
+	if current.stale {
+		age = time.Since(current.loadedAt)
+	}
+	if err != nil {
+		log.Printf(
+			"FAILED TO LOAD CONFIG, serving last good config loaded %v ago (at %v): %v",
+			age.Round(time.Second), current.loadedAt.Format(time.RFC3339), err)
+	}
+	return current.config, age, nil
+}
+
+// loadFixed loads the config file at configURL (see New for supported schemes)
+// once, and returns it along with a Bouncer that serves every request with it,
+// so that simulated requests all see the same config.
+func loadFixed(ctx context.Context, configURL string) (*Bouncer, config, error) {
+	b, err := New(configURL)
+	if err != nil {
+		return nil, config{}, err
+	}
+	c, version, err := b.loadConfig(ctx, configVersion{})
+	if err != nil {
+		return nil, config{}, err
+	}
+	b.current.Store(&loadedConfig{config: c, version: version, loadedAt: time.Now()})
+	b.polling.Store(true) // with nothing polling, the config never changes
+	return b, c, nil
+}
+
+// reloadIfExpired reloads the config unless the current config is within the
+// cache TTL, and returns the config that is current afterward. Concurrent
+// callers share a single reload rather than each waiting for their own.
+func (b *Bouncer) reloadIfExpired(ctx context.Context) (*loadedConfig, error) {
+	if current := b.current.Load(); b.fresh(current) {
+		return current, nil
+	}
+
+	b.inflightMu.Lock()
+	if current := b.current.Load(); b.fresh(current) {
+		b.inflightMu.Unlock()
+		return current, nil
+	}
+	call := b.inflight
+	if call != nil {
+		b.inflightMu.Unlock()
+		<-call.done
+		return call.current, call.err
+	}
+	call = &reloadCall{done: make(chan struct{})}
+	b.inflight = call
+	b.inflightMu.Unlock()
+
+	// Other requests are waiting on this reload, so it can't stop just because
+	// this one's client went away.
+	b.reloadMu.Lock()
+	call.current, _, call.err = b.reloadLocked(context.WithoutCancel(ctx))
+	b.reloadMu.Unlock()
+
+	b.inflightMu.Lock()
+	b.inflight = nil
+	b.inflightMu.Unlock()
+	close(call.done)
+	return call.current, call.err
+}
+
+// reloadCall is a reload that is in progress on behalf of one or more
+// requests. Its results are set before done is closed.
+type reloadCall struct {
+	done    chan struct{}
+	current *loadedConfig
+	err     error
+}
+
+// fresh reports whether current is a config that can be served without
+// checking the source for changes. A stale config is retried after the cache
+// TTL or minRetryInterval, whichever is longer.
+func (b *Bouncer) fresh(current *loadedConfig) bool {
+	switch {
+	case current == nil:
+		return false
+	case current.stale:
+		return time.Since(current.failedAt) < max(b.CacheTTL, minRetryInterval)
+	default:
+		return time.Since(current.loadedAt) < b.CacheTTL
+	}
+}
+
+// Reload immediately loads the config from its source and makes it current,
+// logging the outcome and reporting whether the config changed. If loading
+// fails, the Bouncer continues to serve the last config that loaded
+// successfully.
+func (b *Bouncer) Reload(ctx context.Context) (changed bool, err error) {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	current, changed, err := b.reloadLocked(ctx)
+	switch {
+	case err != nil && current != nil:
+		log.Printf(
+			"FAILED TO RELOAD CONFIG, keeping config loaded %v ago (at %v): %v",
+			time.Since(current.loadedAt).Round(time.Second), current.loadedAt.Format(time.RFC3339), err)
+	case err != nil:
+		log.Printf("FAILED TO RELOAD CONFIG, no config available: %v", err)
+	case changed:
+		log.Printf("reloaded config (ETag: %s, Last-Modified: %s)", current.version.ETag, current.version.LastModified)
+	default:
+		log.Printf("config unchanged")
+	}
+	return changed, err
+}
+
+// Poll switches the Bouncer to serve requests from a config that it reloads in
+// the background, so that requests never wait on the config source. Poll
+// performs an initial reload before returning, then reloads the config every
+// interval plus a random duration of up to jitter until ctx is canceled.
+func (b *Bouncer) Poll(ctx context.Context, interval, jitter time.Duration) {
+	b.polling.Store(true)
+	b.Reload(ctx)
+
+	go func() {
+		for {
+			wait := interval
+			if jitter > 0 {
+				wait += rand.N(jitter)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+				b.Reload(ctx)
+			}
+		}
+	}()
+}
+
+// reloadLocked loads the config and makes it current, reporting whether it
+// changed. On failure, it marks the last good config as stale and returns it
+// along with the error. b.reloadMu must be held.
+func (b *Bouncer) reloadLocked(ctx context.Context) (current *loadedConfig, changed bool, err error) {
+	now := time.Now()
+	last := b.current.Load()
+
+	var prev configVersion
+	if last != nil {
+		prev = last.version
+	}
+
+	c, version, err := b.loadConfig(ctx, prev)
+	switch {
+	case err == nil:
+		current = &loadedConfig{config: c, version: version, loadedAt: now}
+		b.current.Store(current)
+		return current, true, nil
+
+	case errors.Is(err, errNotModified) && last != nil:
+		revalidated := *last
+		revalidated.loadedAt = now
+		revalidated.stale = false
+		b.current.Store(&revalidated)
+		return &revalidated, false, nil
+
+	case last == nil:
+		return nil, false, err
+	}
+
+	stale := *last
+	stale.stale = true
+	stale.failedAt = now
+	b.current.Store(&stale)
+	return &stale, false, err
+}
+
+// loadConfig fetches and decodes the config, returning errNotModified if the
+// source reports that prev is still current.
+func (b *Bouncer) loadConfig(ctx context.Context, prev configVersion) (config, configVersion, error) {
+	r, version, err := b.fetchConfig(ctx, prev)
+	if errors.Is(err, errNotModified) {
+		return config{}, prev, err
+	}
+	if err != nil {
+		return config{}, configVersion{}, fmt.Errorf("fetching config: %w", err)
+	}
+	defer r.Close()
+
+	// Not every source honors conditional requests, but an unchanged ETag is
+	// just as good.
+	if version.ETag != "" && version.ETag == prev.ETag {
+		return config{}, prev, errNotModified
+	}
+
+	c, err := decodeConfig(r)
+	if err != nil {
+		return config{}, configVersion{}, fmt.Errorf("decoding config: %w", err)
+	}
+	return c, version, nil
+}
@@ -0,0 +1,84 @@
+package bouncer
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches the placeholders that can appear in package
+// settings: "{name}", which expands to the value of name, and "{/name}" or
+// "{?name}", which expand to a slash or question mark followed by the value of
+// name if that value is non-empty.
+var placeholderPattern = regexp.MustCompile(`\{([/?]?)([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// The names of placeholders whose values come from the request, rather than
+// from a package prefix.
+const (
+	// pathVar is the full requested import path, including the host.
+	pathVar = "path"
+	// hostVar is the host of the requested import path.
+	hostVar = "host"
+	// restVar is the part of the requested import path after the matching
+	// package prefix, without a leading slash.
+	restVar = "rest"
+	// queryVar is the query string of a browser's request, without the
+	// leading question mark.
+	queryVar = "query"
+)
+
+// requestVars are the names of all placeholders whose values come from the
+// request.
+var requestVars = []string{pathVar, hostVar, restVar, queryVar}
+
+// placeholderName returns the name of the placeholder that makes up a prefix
+// segment, if the segment is a placeholder.
+func placeholderName(segment string) (name string, ok bool) {
+	m := placeholderPattern.FindStringSubmatch(segment)
+	if m == nil || m[0] != segment || m[1] != "" {
+		return "", false
+	}
+	return m[2], true
+}
+
+// placeholderNames returns the names of all placeholders in s.
+func placeholderNames(s string) []string {
+	var names []string
+	for _, m := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+		names = append(names, m[2])
+	}
+	return names
+}
+
+// expandPlaceholders replaces the placeholders in s with values from vars.
+// Placeholders whose names are not in vars are left unchanged.
+func expandPlaceholders(s string, vars map[string]string) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+		m := placeholderPattern.FindStringSubmatch(placeholder)
+		value, ok := vars[m[2]]
+		switch {
+		case !ok:
+			return placeholder
+		case m[1] != "" && value != "":
+			return m[1] + value
+		case m[1] != "":
+			return ""
+		default:
+			return value
+		}
+	})
+}
+
+// browserQuery returns the query string of a request to use for the {query}
+// placeholder, minus the go-get parameter that the go command adds.
+func browserQuery(r *http.Request) string {
+	query := r.URL.Query()
+	if !query.Has("go-get") {
+		return r.URL.RawQuery
+	}
+	query.Del("go-get")
+	return query.Encode()
+}
@@ -0,0 +1,259 @@
+package bouncer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// proxyPath is the URL path, reserved on every host, under which the built-in
+// module proxy serves the GOPROXY protocol.
+const proxyPath = "/.well-known/importbounce/proxy"
+
+// selfProxy is the repo root of a "mod" import that refers to the built-in
+// module proxy on the host of the package.
+const selfProxy = "self"
+
+// proxyConfig holds the settings for the built-in module proxy, which serves
+// the modules of packages with a "mod self" import.
+type proxyConfig struct {
+	// Source is the location of the module files, as a URL with one of the
+	// schemes that New supports. The files are laid out as a GOPROXY server
+	// serves them, like "{source}/example.com/lib/@v/v1.0.0.zip", with module
+	// paths and versions in their escaped form. Packages with a local_repo
+	// don't use it.
+	Source string `toml:"source"`
+
+	// CacheDir is where the files of modules generated from local
+	// repositories are kept, by default in the system's temporary directory.
+	CacheDir string `toml:"cache_dir"`
+}
+
+// moduleFiles opens the files of a single module by their names in the
+// GOPROXY protocol, like "@v/list" or "@v/v1.0.0.zip".
+type moduleFiles func(ctx context.Context, name string) (io.ReadCloser, error)
+
+// validate checks the proxy settings.
+func (pc *proxyConfig) validate(report reportFunc) {
+	if pc.CacheDir != "" && !filepath.IsAbs(pc.CacheDir) {
+		report("cache_dir", "cache_dir must be an absolute path")
+	}
+	if pc.Source == "" {
+		return
+	}
+	u, err := url.Parse(pc.Source)
+	if err != nil {
+		report("source", "source: %v", err)
+		return
+	}
+	factory, ok := fetcherFactories[u.Scheme]
+	if !ok {
+		report("source", "source has unknown URL scheme %q", u.Scheme)
+		return
+	}
+	// The source is set up as the config loads, so that one that can't be
+	// used, like an S3 bucket without an AWS config, fails the load rather
+	// than the requests for modules.
+	if _, err := factory(u); err != nil {
+		report("source", "source: %v", err)
+	}
+}
+
+// open opens the file at the slash-separated name within the source.
+func (pc *proxyConfig) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	fileURL := strings.TrimSuffix(pc.Source, "/") + "/" + name
+	fetch, err := getFetcherFromURL(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	// Module zips can take far longer to download than a config file.
+	if u, _ := url.Parse(fileURL); u.Scheme == "http" || u.Scheme == "https" {
+		fetch = getHTTPFetcher(u, streamingClient)
+	}
+	r, _, err := fetch(ctx, configVersion{})
+	return r, err
+}
+
+// moduleProxyURL returns the URL of the module proxy in the package's import
+// list, with a "mod self" entry referring to the built-in module proxy on the
+// host of the package's prefix, or "" if the list has no "mod" entry.
+func (p packageConfig) moduleProxyURL() string {
+	proxy := p.Import.moduleProxy()
+	if proxy == selfProxy {
+		host, _, _ := strings.Cut(p.Prefix, "/")
+		return "https://" + host + proxyPath
+	}
+	return proxy
+}
+
+// selfProxied returns the settings of the package, and of each of its major
+// versions, whose modules the built-in module proxy serves.
+func (p *packageConfig) selfProxied() []packageConfig {
+	var proxied []packageConfig
+	if p.Import.moduleProxy() == selfProxy {
+		proxied = append(proxied, *p)
+	}
+	for _, major := range p.majors() {
+		if v := p.forVersion(major); v.Import.moduleProxy() == selfProxy {
+			proxied = append(proxied, v)
+		}
+	}
+	return proxied
+}
+
+// serveProxy serves a GOPROXY protocol request for a file of a module, where
+// rest is the part of the URL path after proxyPath and its trailing slash.
+// Only the modules of packages with a "mod self" import are served, and only
+// under the module path that matches the package's prefix.
+func (c *config) serveProxy(w http.ResponseWriter, r *http.Request, rest string) {
+	escapedPath, file, ok := strings.Cut(rest, "/@v/")
+	if !ok {
+		escapedPath, ok = strings.CutSuffix(rest, "/@latest")
+		file = "@latest"
+	}
+	modPath, err := module.UnescapePath(escapedPath)
+	if !ok || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	host, _, _ := strings.Cut(modPath, "/")
+	hostConf := c.lookupHost(host)
+	if hostConf == nil {
+		http.NotFound(w, r)
+		return
+	}
+	pkgConf := hostConf.findPackage(modPath)
+	if pkgConf.Prefix != modPath || pkgConf.Status == statusRetired || pkgConf.Import.moduleProxy() != selfProxy {
+		http.NotFound(w, r)
+		return
+	}
+	goGetPolicy := c.responseSettings(hostConf, &pkgConf).cache.GoGet
+	policy := goGetPolicy
+
+	open := moduleFiles(func(ctx context.Context, name string) (io.ReadCloser, error) {
+		return c.Proxy.open(ctx, escapedPath+"/"+name)
+	})
+	if pkgConf.LocalRepo != "" {
+		open = c.Proxy.gitModule(pkgConf).open
+	}
+
+	var (
+		f           io.ReadCloser
+		contentType string
+	)
+	switch ext := path.Ext(file); {
+	case file == "list":
+		f, err = open(r.Context(), "@v/list")
+		contentType = "text/plain; charset=utf-8"
+	case file == "@latest":
+		f, err = open.latest(r.Context())
+		contentType = "application/json"
+	case ext == ".info" || ext == ".mod" || ext == ".zip":
+		version, verr := module.UnescapeVersion(strings.TrimSuffix(file, ext))
+		if verr != nil || module.Check(modPath, version) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, err = open(r.Context(), "@v/"+file)
+		contentType = map[string]string{
+			".info": "application/json",
+			".mod":  "text/plain; charset=utf-8",
+			".zip":  "application/zip",
+		}[ext]
+		// The files for a module version never change, unlike the list of
+		// versions that are available.
+		policy = &cachePolicy{CacheControl: "public, max-age=31536000, immutable"}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case isNotExist(err):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		log.Printf("failed to open %s from module proxy source: %v", rest, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// With a Content-Length, a response that is cut short can't pass for a
+	// complete one, so only a file of known size is safe to cache forever.
+	if size := bodySize(f); size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	} else {
+		policy = goGetPolicy
+	}
+	if header := policy.header(); header != "" {
+		w.Header().Set("Cache-Control", header)
+	}
+	w.Header().Set("Content-Type", contentType)
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("failed to serve %s from module proxy source: %v", rest, err)
+	}
+}
+
+// latest opens the info for the latest version of the module: its "@latest"
+// file if it has one, or else the info for the highest release version in its
+// version list, or the highest pre-release version if there are no releases.
+func (open moduleFiles) latest(ctx context.Context) (io.ReadCloser, error) {
+	f, err := open(ctx, "@latest")
+	if !isNotExist(err) {
+		return f, err
+	}
+
+	list, err := open(ctx, "@v/list")
+	if err != nil {
+		return nil, err
+	}
+	defer list.Close()
+
+	var latest string
+	scanner := bufio.NewScanner(list)
+	for scanner.Scan() {
+		version := strings.TrimSpace(scanner.Text())
+		if semver.IsValid(version) && (latest == "" || latestRank(version, latest) > 0) {
+			latest = version
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading version list: %w", err)
+	}
+	if latest == "" {
+		return nil, fmt.Errorf("no versions: %w", fs.ErrNotExist)
+	}
+
+	escapedVersion, err := module.EscapeVersion(latest)
+	if err != nil {
+		return nil, err
+	}
+	return open(ctx, "@v/"+escapedVersion+".info")
+}
+
+// latestRank compares two versions in the order of preference for the latest
+// version of a module, where any release is preferred to any pre-release.
+func latestRank(v, w string) int {
+	vRelease, wRelease := semver.Prerelease(v) == "", semver.Prerelease(w) == ""
+	switch {
+	case vRelease && !wRelease:
+		return 1
+	case wRelease && !vRelease:
+		return -1
+	default:
+		return semver.Compare(v, w)
+	}
+}
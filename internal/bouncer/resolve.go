@@ -0,0 +1,160 @@
+package bouncer
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+)
+
+// Resolve explains how a Bouncer using the config file at configURL (see New
+// for supported schemes) handles requests for each of the provided import
+// paths, writing a report to w. Each path is a hostname followed by a URL path.
+//
+// For each path, the report shows the responses to the go command and to web
+// browsers, which come from requests served by a real Bouncer, along with the
+// reason that each configured package did or did not match.
+func Resolve(ctx context.Context, configURL string, w io.Writer, paths ...string) error {
+	b, c, err := loadFixed(ctx, configURL)
+	if err != nil {
+		return err
+	}
+
+	for i, path := range paths {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, path)
+
+		goGet, err := b.simulate(ctx, path, true)
+		if err != nil {
+			return err
+		}
+		browser, err := b.simulate(ctx, path, false)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "  go command: %s\n", goGet)
+		fmt.Fprintf(w, "  browser:    %s\n", browser)
+
+		host, rest, _ := strings.Cut(path, "/")
+		host = normalizeHost(host)
+		path, err = canonicalImportPath(host, rest)
+		if err != nil {
+			fmt.Fprintf(w, "  %v\n", err)
+			continue
+		}
+		if !c.Server.allowsHost(host) {
+			fmt.Fprintf(w, "  host %q is not in server.allowed_hosts\n", host)
+			continue
+		}
+		hostConf := c.lookupHost(host)
+		if hostConf == nil {
+			if c.UnknownHost != nil && c.UnknownHost.Redirect != "" {
+				fmt.Fprintf(w, "  host %q is not configured, so browsers go to unknown_host.redirect\n", host)
+			} else {
+				fmt.Fprintf(w, "  host %q is not configured, and no unknown_host.redirect is set\n", host)
+			}
+			continue
+		}
+		if hostConf.name != "" && hostConf.name != host {
+			fmt.Fprintf(w, "  host %q is an alias of %q\n", host, hostConf.name)
+		}
+		isRoot := path == host
+		path = hostConf.canonicalPath(path)
+
+		fmt.Fprintln(w, "  packages:")
+		if len(hostConf.Packages) == 0 {
+			fmt.Fprintln(w, "    (none configured)")
+		}
+		matched := hostConf.index.match(path)
+		selected := hostConf.findPackage(path)
+		if selected.selector != "" {
+			_, matched = hostConf.findSelected(path)
+		}
+		for i, pkgConf := range hostConf.Packages {
+			var outcome string
+			switch ok, reason := matchPrefix(pkgConf.Prefix, path); {
+			case i == matched && selected.selector != "":
+				outcome = fmt.Sprintf("MATCHED (version selector %s)", selected.selector)
+			case i == matched:
+				outcome = "MATCHED"
+				if _, major := pkgConf.versionedPrefix(splitPath(path), len(splitPath(pkgConf.Prefix))); major != "" {
+					outcome += fmt.Sprintf(" (versions.%s)", major)
+				}
+			case ok && selected.selector != "":
+				outcome = "not used, as a version selector matched"
+			case ok && pkgConf.isPattern() && !hostConf.Packages[matched].isPattern():
+				outcome = "not used, as an explicit prefix matched"
+			case ok:
+				outcome = "not used, as a more specific prefix matched"
+			default:
+				outcome = "no match: " + reason
+			}
+			fmt.Fprintf(w, "    %s (prefix %q): %s\n", hostConf.packageKey(i), pkgConf.Prefix, outcome)
+		}
+		if selected.selector != "" {
+			fmt.Fprintf(w, "  the go command clones https://%s through importbounce, with HEAD at the newest %s branch or tag\n",
+				selected.Prefix, selected.selector)
+		}
+		if matched < 0 {
+			switch {
+			case isRoot && hostConf.IndexPage:
+				fmt.Fprintf(w, "  no package matched, so browsers get the index page for the host\n")
+			case cmp.Or(hostConf.DefaultRedirect, c.DefaultRedirect) != "":
+				fmt.Fprintf(w, "  no package matched, so browsers go to default_redirect\n")
+			default:
+				fmt.Fprintf(w, "  no package matched, and no default_redirect is set\n")
+			}
+		}
+	}
+	return nil
+}
+
+var goMetaPattern = regexp.MustCompile(`<meta name="go-(import|source)"[^>]*>`)
+
+// simulate serves a request for path as either the go command or a web browser
+// would make it, and summarizes the response.
+func (b *Bouncer) simulate(ctx context.Context, path string, goGet bool) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, simulatedURL(path, goGet), nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid import path %q: %w", path, err)
+	}
+
+	rec := httptest.NewRecorder()
+	b.ServeHTTP(rec, req)
+	resp := rec.Result()
+
+	status := fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	switch {
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		return fmt.Sprintf("%s -> %s", status, resp.Header.Get("Location")), nil
+	case resp.StatusCode == http.StatusOK:
+		if tags := goMetaPattern.FindAllString(rec.Body.String(), -1); len(tags) > 0 {
+			return fmt.Sprintf("%s\n    %s", status, strings.Join(tags, "\n    ")), nil
+		}
+	}
+	// Plain text bodies are short messages, unlike pages meant for browsers.
+	if body := strings.TrimSpace(rec.Body.String()); body != "" && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		return fmt.Sprintf("%s %q", status, body), nil
+	}
+	return status, nil
+}
+
+// simulatedURL returns the URL that the go command or a web browser requests
+// for an import path, whose URL path is at least "/" like that of any real
+// request.
+func simulatedURL(path string, goGet bool) string {
+	target := "https://" + path
+	if !strings.Contains(path, "/") {
+		target += "/"
+	}
+	if goGet {
+		target += "?go-get=1"
+	}
+	return target
+}
@@ -0,0 +1,80 @@
+package bouncer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	const configTOML = `
+[[packages]]
+prefix = "example.com"
+import = "git https://git.example.org/root"
+redirect = "https://pkg.go.dev/example.com"
+
+[[packages]]
+prefix = "example.com/lib"
+import = "git https://git.example.org/lib"
+redirect = "https://pkg.go.dev/example.com/lib"
+`
+
+	path := filepath.Join(t.TempDir(), "importbounce.toml")
+	if err := os.WriteFile(path, []byte(configTOML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := Resolve(context.Background(), "file://"+path, &out, "example.com", "example.com/lib/"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"example.com\n  go command: 200 OK\n" +
+			`    <meta name="go-import" content="example.com git https://git.example.org/root">` + "\n" +
+			"  browser:    302 Found -> https://pkg.go.dev/example.com\n",
+		"example.com/lib/\n  go command: 404 Not Found \"Not a canonical import path\"\n" +
+			"  browser:    301 Moved Permanently -> /lib\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain:\n%s\ngot:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestResolveIndexPage(t *testing.T) {
+	const configTOML = `
+default_redirect = "https://example.com/search?q={path}"
+
+[hosts."go.example.com"]
+index_page = true
+
+[[hosts."go.example.com".packages]]
+prefix = "lib"
+import = "git https://git.example.org/lib"
+redirect = "https://pkg.go.dev/go.example.com/lib"
+`
+
+	path := filepath.Join(t.TempDir(), "importbounce.toml")
+	if err := os.WriteFile(path, []byte(configTOML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := Resolve(context.Background(), "file://"+path, &out, "go.example.com", "go.example.com/other"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"go.example.com\n  go command: 404 Not Found \"Package not found\"\n  browser:    200 OK\n",
+		"  no package matched, so browsers get the index page for the host\n",
+		"go.example.com/other\n",
+		"  no package matched, so browsers go to default_redirect\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain:\n%s\ngot:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package bouncer
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// The forms of version selector that a package can support, in the style of
+// gopkg.in: a suffix on the last segment of the prefix, like
+// "example.com/pkg.v3", or a segment right after the host, like
+// "example.com/v3/pkg".
+const (
+	selectorSuffix = "suffix"
+	selectorPath   = "path"
+)
+
+// findSelected returns the package for a path with a version selector, with
+// its prefix including the selector, along with its index in h.Packages. If
+// the path has no selector that a package supports, it returns a zero
+// packageConfig and -1.
+func (h *hostConfig) findSelected(path string) (packageConfig, int) {
+	segments := splitPath(path)
+	if len(segments) > 1 {
+		if major := segments[1]; isSelector(major) {
+			rest := slices.Delete(slices.Clone(segments), 1, 2)
+			if p, i := h.matchPackage(strings.Join(rest, "/"), major); p.VersionSelector == selectorPath {
+				return p, i
+			}
+		}
+	}
+	for n := 1; n < len(segments); n++ {
+		name, major, ok := cutSelectorSuffix(segments[n])
+		if !ok {
+			continue
+		}
+		unselected := slices.Clone(segments)
+		unselected[n] = name
+		p, i := h.matchPackage(strings.Join(unselected, "/"), major)
+		if p.VersionSelector == selectorSuffix && len(splitPath(p.Prefix)) == n+1 {
+			return p, i
+		}
+	}
+	return packageConfig{}, -1
+}
+
+// withSelector returns the segments of an import path with the package's
+// selector added, given the segments of the path without it and the number of
+// segments in the package's prefix.
+func (p *packageConfig) withSelector(segments []string, prefixLen int) []string {
+	segments = slices.Clone(segments)
+	if p.VersionSelector == selectorPath {
+		return slices.Insert(segments, 1, p.selector)
+	}
+	segments[prefixLen-1] += "." + p.selector
+	return segments
+}
+
+// isSelector reports whether s is a major version selector, like "v3".
+func isSelector(s string) bool {
+	return semver.IsValid(s) && semver.Major(s) == s
+}
+
+// cutSelectorSuffix splits a path segment like "pkg.v3" into a name and the
+// major version that its suffix selects.
+func cutSelectorSuffix(segment string) (name, major string, ok bool) {
+	i := strings.LastIndexByte(segment, '.')
+	if i <= 0 {
+		return "", "", false
+	}
+	name, major = segment[:i], segment[i+1:]
+	return name, major, isSelector(major)
+}
+
+// selectRef returns the hash and name of the ref that a major version selects
+// among the refs of a repository: the branch or tag with the highest version
+// among those named like "v3", "v3.1" or "v3.1.2", with branches taking
+// precedence over tags of the same name. Annotated tags select their commit.
+func selectRef(refs []gitRef, major string) (hash, name string, ok bool) {
+	peeled := make(map[string]string)
+	for _, ref := range refs {
+		if tag, ok := strings.CutSuffix(ref.name, "^{}"); ok {
+			peeled[tag] = ref.hash
+		}
+	}
+
+	var best string
+	for _, ref := range refs {
+		v, isBranch := strings.CutPrefix(ref.name, "refs/heads/")
+		if !isBranch {
+			var isTag bool
+			if v, isTag = strings.CutPrefix(ref.name, "refs/tags/"); !isTag {
+				continue
+			}
+		}
+		if !semver.IsValid(v) || semver.Major(v) != major || semver.Prerelease(v) != "" || semver.Build(v) != "" {
+			continue
+		}
+		if c := semver.Compare(v, best); ok && (c < 0 || c == 0 && (!isBranch || strings.HasPrefix(name, "refs/heads/"))) {
+			continue
+		}
+		hash, name, best, ok = ref.hash, ref.name, v, true
+		if commit, annotated := peeled[ref.name]; annotated {
+			hash = commit
+		}
+	}
+	return hash, name, ok
+}
+
+// validateSelector checks the version_selector setting of a package, which
+// needs a Git repository that the Bouncer can proxy.
+func (p *packageConfig) validateSelector(report reportFunc) {
+	switch p.VersionSelector {
+	case "":
+		return
+	case selectorPath:
+	case selectorSuffix:
+		if !strings.Contains(strings.TrimSuffix(p.Prefix, "/"), "/") {
+			report("version_selector", "version_selector %q requires a prefix with a path after the host", selectorSuffix)
+		}
+	default:
+		report("version_selector", "version_selector must be %q or %q", selectorSuffix, selectorPath)
+		return
+	}
+
+	vcs, root, ok := p.Import.repoRoot()
+	switch {
+	case p.Import.moduleProxy() != "":
+		report("version_selector", "version_selector is not supported with a \"mod\" import")
+	case !ok || vcs != "git":
+		report("version_selector", "version_selector requires a git import")
+	case !strings.HasPrefix(root, "https://") && !strings.HasPrefix(root, "http://"):
+		report("version_selector", "version_selector requires a git repo root with an http or https URL")
+	}
+}
@@ -0,0 +1,159 @@
+package bouncer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// serverConfig holds settings for how the Bouncer treats incoming requests,
+// independent of the packages it serves.
+type serverConfig struct {
+	// AllowedHosts lists the only hosts for which requests are served, if it is
+	// not empty. Requests for other hosts are rejected with RejectedHostStatus.
+	AllowedHosts       []string `toml:"allowed_hosts"`
+	RejectedHostStatus int      `toml:"rejected_host_status"`
+
+	TrustedProxies []trustedProxy `toml:"trusted_proxies"`
+}
+
+// trustedProxy permits a request from one of a set of addresses to set its
+// host through a forwarding header, rather than through the Host header.
+type trustedProxy struct {
+	From   []string `toml:"from"`   // IP addresses or CIDR ranges
+	Header string   `toml:"header"` // one of forwardedHeaders
+}
+
+// forwardedHeaders are the headers that a trusted proxy can use to forward the
+// original host of a request.
+var forwardedHeaders = []string{"Forwarded", "X-Forwarded-Host"}
+
+// rejectedHostStatuses are the allowed values for RejectedHostStatus, the first
+// of which is the default.
+var rejectedHostStatuses = []int{http.StatusMisdirectedRequest, http.StatusNotFound}
+
+// requestHost returns the normalized host that a request is for, taken from a
+// forwarding header if the request comes from a trusted proxy, and reports
+// whether the host is allowed.
+func (s *serverConfig) requestHost(r *http.Request) (host string, ok bool) {
+	host = r.Host
+	if proxy := s.trustedProxy(r.RemoteAddr); proxy != nil {
+		if forwarded := proxy.forwardedHost(r.Header); forwarded != "" {
+			host = forwarded
+		}
+	}
+	host = normalizeHost(host)
+	return host, s.allowsHost(host)
+}
+
+// allowsHost reports whether requests for a normalized host are served.
+func (s *serverConfig) allowsHost(host string) bool {
+	if len(s.AllowedHosts) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.AllowedHosts, func(allowed string) bool {
+		return strings.EqualFold(allowed, host)
+	})
+}
+
+// rejectedHostStatus returns the status code for requests for hosts that
+// aren't allowed.
+func (s *serverConfig) rejectedHostStatus() int {
+	if s.RejectedHostStatus != 0 {
+		return s.RejectedHostStatus
+	}
+	return rejectedHostStatuses[0]
+}
+
+// trustedProxy returns the first trusted proxy rule that covers the remote
+// address of a request, or nil if there is none.
+func (s *serverConfig) trustedProxy(remoteAddr string) *trustedProxy {
+	if len(s.TrustedProxies) == 0 {
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	addr, err := netip.ParseAddr(remoteAddr)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+
+	for i, proxy := range s.TrustedProxies {
+		for _, from := range proxy.From {
+			if prefix, err := parseAddrOrPrefix(from); err == nil && prefix.Contains(addr) {
+				return &s.TrustedProxies[i]
+			}
+		}
+	}
+	return nil
+}
+
+// forwardedHost returns the host forwarded by the proxy in the request
+// headers, or "" if there is none. As each proxy appends to the headers, the
+// value from the proxy nearest to the Bouncer comes last.
+func (p *trustedProxy) forwardedHost(header http.Header) string {
+	values := strings.Join(header.Values(p.Header), ",")
+	if values == "" {
+		return ""
+	}
+	elems := strings.Split(values, ",")
+	last := strings.TrimSpace(elems[len(elems)-1])
+
+	if !strings.EqualFold(p.Header, "Forwarded") {
+		return last
+	}
+	for _, pair := range strings.Split(last, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if strings.EqualFold(key, "host") {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// parseAddrOrPrefix parses an IP address or CIDR range, treating an address
+// as a range that contains only that address.
+func parseAddrOrPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// validate checks the server settings.
+func (s *serverConfig) validate(reporter func(key, prefix string) reportFunc) {
+	report := reporter("server", "")
+	for _, host := range s.AllowedHosts {
+		if err := checkHostName(host); err != nil {
+			report("allowed_hosts", "allowed_hosts: %v", err)
+		}
+	}
+	if s.RejectedHostStatus != 0 && !slices.Contains(rejectedHostStatuses, s.RejectedHostStatus) {
+		report("rejected_host_status", "rejected_host_status must be %d or %d", rejectedHostStatuses[0], rejectedHostStatuses[1])
+	}
+
+	for i, proxy := range s.TrustedProxies {
+		report := reporter(fmt.Sprintf("server.trusted_proxies[%d]", i), "")
+		if len(proxy.From) == 0 {
+			report("from", "missing from")
+		}
+		for _, from := range proxy.From {
+			if _, err := parseAddrOrPrefix(from); err != nil {
+				report("from", "from: %q is not an IP address or CIDR range", from)
+			}
+		}
+		if !slices.ContainsFunc(forwardedHeaders, func(h string) bool { return strings.EqualFold(h, proxy.Header) }) {
+			report("header", "header must be one of %s", strings.Join(forwardedHeaders, ", "))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package bouncer
+
+import (
+	"slices"
+	"strings"
+)
+
+// forgeShorthands lists the keys for forge shorthand settings, like
+// github = "owner/repo", along with the base URL of the repositories on each
+// forge.
+var forgeShorthands = []struct {
+	Key  string
+	Base string
+}{
+	{"github", "https://github.com/"},
+	{"gitlab", "https://gitlab.com/"},
+	{"codeberg", "https://codeberg.org/"},
+	{"sourcehut", "https://git.sr.ht/"},
+	{"bitbucket", "https://bitbucket.org/"},
+}
+
+// shorthands returns the forge shorthand settings that are set on the package,
+// keyed by their TOML keys.
+func (p *packageConfig) shorthands() map[string]string {
+	all := map[string]string{
+		"github":    p.GitHub,
+		"gitlab":    p.GitLab,
+		"codeberg":  p.Codeberg,
+		"sourcehut": p.Sourcehut,
+		"bitbucket": p.Bitbucket,
+	}
+	for key, repo := range all {
+		if repo == "" {
+			delete(all, key)
+		}
+	}
+	return all
+}
+
+// expandShorthands fills in the import and redirect settings of packages that
+// use a forge shorthand and don't set them explicitly. Source links need no
+// expansion, as the forges are all recognized by their hosts.
+func (c *config) expandShorthands() {
+	for _, pkgs := range c.allPackages() {
+		for i := range pkgs {
+			pkgs[i].expandShorthand()
+		}
+	}
+}
+
+// expandShorthand fills in the settings of a single package from its forge
+// shorthand, as described by config.expandShorthands.
+func (p *packageConfig) expandShorthand() {
+	shorthands := p.shorthands()
+	if len(shorthands) != 1 {
+		return // Leave it for validation to report.
+	}
+
+	for _, forge := range forgeShorthands {
+		repo, ok := shorthands[forge.Key]
+		if !ok {
+			continue
+		}
+		if len(p.Import) == 0 {
+			p.Import = importList{"git " + forge.Base + strings.Trim(repo, "/")}
+		}
+		if p.Redirect == "" {
+			p.Redirect = "https://pkg.go.dev/{path}"
+		}
+	}
+}
+
+// validateShorthands checks the package's forge shorthand settings.
+func (p *packageConfig) validateShorthands(report reportFunc) {
+	shorthands := p.shorthands()
+	if len(shorthands) > 1 {
+		report("", "only one of %s can be set", shorthandKeys())
+	}
+	for _, forge := range forgeShorthands {
+		repo, ok := shorthands[forge.Key]
+		if !ok {
+			continue
+		}
+		segments := strings.Split(strings.Trim(repo, "/"), "/")
+		if len(segments) < 2 || strings.Contains(repo, "://") || slices.Contains(segments, "") {
+			report(forge.Key, "%s = %q must have the form \"owner/repo\"", forge.Key, repo)
+		}
+	}
+}
+
+func shorthandKeys() string {
+	keys := make([]string, len(forgeShorthands))
+	for i, forge := range forgeShorthands {
+		keys[i] = forge.Key
+	}
+	return strings.Join(keys, ", ")
+}
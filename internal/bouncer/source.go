@@ -0,0 +1,165 @@
+package bouncer
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// sourceConfig holds the URL templates for a package's go-source meta tag, as
+// described at https://github.com/golang/gddo/wiki/Source-Code-Links. The
+// Directory and File templates can use the {dir}, {/dir}, {file} and {line}
+// placeholders, which tools like pkgsite fill in.
+type sourceConfig struct {
+	// Forge names the kind of forge that hosts the repository, to fill in
+	// any templates that aren't set explicitly. It only needs to be set for
+	// self-hosted forges, as repositories on well-known hosts are detected
+	// automatically.
+	Forge string `toml:"forge"`
+
+	// Branch is the branch that the default templates for the forge link to,
+	// for forges that can't link to the default branch of a repository.
+	Branch string `toml:"branch"`
+
+	Home      string `toml:"home"`
+	Directory string `toml:"directory"`
+	File      string `toml:"file"`
+}
+
+// goSourceVars are the placeholders defined for go-source templates, which the
+// Bouncer leaves for clients to fill in.
+var goSourceVars = []string{"dir", "file", "line"}
+
+// forgeHosts maps the hosts of well-known forges to the kind of forge they run.
+var forgeHosts = map[string]string{
+	"github.com":    "github",
+	"gitlab.com":    "gitlab",
+	"codeberg.org":  "gitea",
+	"gitea.com":     "gitea",
+	"git.sr.ht":     "sourcehut",
+	"bitbucket.org": "bitbucket",
+}
+
+// forgeTemplates holds the default go-source directory and file templates for
+// each kind of forge, in terms of the repository's web URL ({repo}), its
+// branch ({branch}), and the subdirectory holding the module ({/subdir}).
+var forgeTemplates = map[string]struct {
+	Directory, File, Branch string
+}{
+	"github": {
+		Directory: "{repo}/tree/{branch}{/subdir}{/dir}",
+		File:      "{repo}/blob/{branch}{/subdir}{/dir}/{file}#L{line}",
+		Branch:    "HEAD",
+	},
+	"gitlab": {
+		Directory: "{repo}/-/tree/{branch}{/subdir}{/dir}",
+		File:      "{repo}/-/blob/{branch}{/subdir}{/dir}/{file}#L{line}",
+		Branch:    "HEAD",
+	},
+	"gitea": {
+		Directory: "{repo}/src/branch/{branch}{/subdir}{/dir}",
+		File:      "{repo}/src/branch/{branch}{/subdir}{/dir}/{file}#L{line}",
+		Branch:    "main",
+	},
+	"sourcehut": {
+		Directory: "{repo}/tree/{branch}/item{/subdir}{/dir}",
+		File:      "{repo}/tree/{branch}/item{/subdir}{/dir}/{file}#L{line}",
+		Branch:    "HEAD",
+	},
+	"bitbucket": {
+		Directory: "{repo}/src/{branch}{/subdir}{/dir}",
+		File:      "{repo}/src/{branch}{/subdir}{/dir}/{file}#lines-{line}",
+		Branch:    "HEAD",
+	},
+}
+
+// GoSource returns the settings for the package's go-source meta tag, filling
+// in any that aren't set explicitly from the defaults for the forge hosting
+// its repository. It returns nil if the package has no complete source
+// settings, or if a version selector picked the package's ref, since the
+// source links would point at the repository's default branch instead.
+func (p packageConfig) GoSource() *sourceConfig {
+	if p.selector != "" {
+		return nil
+	}
+	src := p.Source
+
+	var repo string
+	if _, root, ok := p.Import.repoRoot(); ok {
+		repo = strings.TrimSuffix(root, ".git")
+	}
+	if src.Forge == "" && repo != "" {
+		if u, err := url.Parse(repo); err == nil {
+			src.Forge = forgeHosts[u.Host]
+		}
+	}
+
+	if tmpl, ok := forgeTemplates[src.Forge]; ok && repo != "" {
+		if src.Branch == "" {
+			src.Branch = tmpl.Branch
+		}
+		vars := map[string]string{"repo": repo, "branch": src.Branch, "subdir": p.Subdir}
+		if src.Home == "" {
+			src.Home = repo
+		}
+		if src.Directory == "" {
+			src.Directory = expandPlaceholders(tmpl.Directory, vars)
+		}
+		if src.File == "" {
+			src.File = expandPlaceholders(tmpl.File, vars)
+		}
+	}
+
+	if src.Home == "" || src.Directory == "" || src.File == "" {
+		return nil
+	}
+	return &src
+}
+
+// validateSource checks the package's source settings, given sample values for the
+// placeholders in its prefix.
+func (p *packageConfig) validateSource(report reportFunc, vars map[string]string) {
+	if p.Source == (sourceConfig{}) {
+		return
+	}
+
+	if p.Source.Forge != "" {
+		if _, ok := forgeTemplates[p.Source.Forge]; !ok {
+			forges := make([]string, 0, len(forgeTemplates))
+			for forge := range forgeTemplates {
+				forges = append(forges, forge)
+			}
+			slices.Sort(forges)
+			report("source", "source: unknown forge %q (want one of %s)", p.Source.Forge, strings.Join(forges, ", "))
+			return
+		}
+	}
+
+	templateVars := make(map[string]string)
+	for name, value := range vars {
+		templateVars[name] = value
+	}
+	for _, name := range goSourceVars {
+		templateVars[name] = "x"
+	}
+	for _, tmpl := range []struct{ name, value string }{
+		{"home", p.Source.Home},
+		{"directory", p.Source.Directory},
+		{"file", p.Source.File},
+	} {
+		if tmpl.value == "" {
+			continue
+		}
+		if err := checkPlaceholders(tmpl.value, templateVars); err != nil {
+			report("source", "source %s: %v", tmpl.name, err)
+		} else if err := checkURL(expandPlaceholders(tmpl.value, templateVars)); err != nil {
+			report("source", "source %s: %v", tmpl.name, err)
+		}
+	}
+
+	sample := *p
+	sample.Import = p.Import.expand(vars)
+	if sample.GoSource() == nil {
+		report("source", "source: home, directory and file must all be set, unless the repository is on a known forge or source.forge is set")
+	}
+}
@@ -0,0 +1,107 @@
+package bouncer
+
+import (
+	"fmt"
+	"html/template"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// The statuses that a package can have besides the default of being active.
+const (
+	// statusActive is the status of a package without one, as reported in
+	// the package catalog.
+	statusActive = "active"
+	// statusDeprecated packages are served to the go command as usual, but
+	// web visitors see a notice instead of being redirected.
+	statusDeprecated = "deprecated"
+	// statusMoved packages are served to the go command as usual, but web
+	// visitors are permanently redirected to the successor.
+	statusMoved = "moved"
+	// statusRetired packages are gone, for the go command and web visitors
+	// alike.
+	statusRetired = "retired"
+)
+
+var packageStatuses = []string{statusDeprecated, statusMoved, statusRetired}
+
+// Notice returns the text that explains the status of the package.
+func (p packageConfig) Notice() string {
+	if p.Message != "" {
+		return strings.TrimSpace(p.Message)
+	}
+
+	var notice string
+	switch p.Status {
+	case statusDeprecated:
+		notice = fmt.Sprintf("%s is deprecated.", p.Prefix)
+	case statusMoved:
+		notice = fmt.Sprintf("%s has moved.", p.Prefix)
+	case statusRetired:
+		notice = fmt.Sprintf("%s has been retired.", p.Prefix)
+	}
+	if p.Successor != "" {
+		notice += fmt.Sprintf(" Use %s instead.", p.Successor)
+	}
+	return notice
+}
+
+// versionSuccessor returns the successor setting for a major version of the
+// package, like "v2", which is the package's successor with the same major
+// version suffix.
+func (p packageConfig) versionSuccessor(major string) string {
+	if p.Successor == "" {
+		return ""
+	}
+	return p.Successor + "/" + major
+}
+
+// successorURL returns the URL for the successor of the package, with the part
+// of the requested import path after the package's prefix appended to it.
+func (p packageConfig) successorURL(path, query string) string {
+	url := "https://" + p.Successor + strings.TrimPrefix(path, p.Prefix)
+	if query != "" {
+		url += "?" + query
+	}
+	return url
+}
+
+var noticeTmpl = template.Must(template.New("").Parse(`<html>
+<head>
+<title>{{.Prefix}}</title>
+</head>
+<body>
+<h1>{{.Prefix}}</h1>
+<p>{{.Notice}}</p>
+{{- with .Successor}}
+<p>See <a href="https://{{.}}">{{.}}</a>.</p>
+{{- end}}
+<p><a href="{{.Redirect}}">Continue anyway</a></p>
+</body>
+</html>
+`))
+
+// validateStatus checks the status settings of a package, with the
+// placeholders from its prefix set in vars.
+func (p *packageConfig) validateStatus(report reportFunc, vars map[string]string) {
+	if p.Status != "" && !slices.Contains(packageStatuses, p.Status) {
+		report("status", "status %q must be one of %s", p.Status, strings.Join(packageStatuses, ", "))
+	}
+	if p.Status == "" && (p.Message != "" || p.Successor != "") {
+		report("status", "message and successor require a status")
+	}
+
+	if p.Successor == "" {
+		if p.Status == statusMoved {
+			report("successor", "a moved package must have a successor")
+		}
+		return
+	}
+	if err := checkPlaceholders(p.Successor, vars); err != nil {
+		report("successor", "successor: %v", err)
+	} else if err := module.CheckImportPath(expandPlaceholders(p.Successor, vars)); err != nil {
+		report("successor", "successor: %v", err)
+	}
+}
@@ -0,0 +1,418 @@
+package bouncer
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configError describes a problem with one part of a config, identified by a
+// key path like "packages[2]" and, for packages, the prefix of the package.
+// Field optionally narrows the problem down to a specific key within the part.
+type configError struct {
+	Key     string
+	Field   string
+	Prefix  string
+	Message string
+}
+
+func (e *configError) Error() string {
+	if e.Prefix != "" {
+		return fmt.Sprintf("%s (prefix %q): %s", e.Key, e.Prefix, e.Message)
+	}
+	return e.Key + ": " + e.Message
+}
+
+// configErrors is the set of problems found while validating a config.
+type configErrors []*configError
+
+func (errs configErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return "invalid config:\n" + strings.Join(msgs, "\n")
+}
+
+// reportFunc reports a problem with a specific field of some part of a config.
+type reportFunc func(field, format string, args ...any)
+
+// knownVCS are the VCS types that the go command accepts in a go-import tag.
+var knownVCS = []string{"bzr", "fossil", "git", "hg", "mod", "svn"}
+
+// validate checks the config for problems that would cause the Bouncer to serve
+// broken or ambiguous responses, as well as for keys in the TOML that don't
+// correspond to any setting.
+func (c *config) validate(md toml.MetaData) configErrors {
+	var errs configErrors
+	reporter := func(key, prefix string) reportFunc {
+		return func(field, format string, args ...any) {
+			errs = append(errs, &configError{
+				Key:     key,
+				Field:   field,
+				Prefix:  prefix,
+				Message: fmt.Sprintf(format, args...),
+			})
+		}
+	}
+
+	for _, key := range undecodedKeys(md) {
+		reporter(key, "")("", "unknown key")
+	}
+
+	if c.DefaultRedirect != "" {
+		if err := checkDefaultRedirect(c.DefaultRedirect); err != nil {
+			reporter("default_redirect", "")("", "%v", err)
+		}
+	}
+	if err := checkRedirectStatus(c.RedirectStatus); err != nil {
+		reporter("redirect_status", "")("", "%v", err)
+	}
+	c.Cache.validate(reporter("cache", ""), "")
+	if c.IndexPageTemplate != "" {
+		if _, err := parseIndexTemplate(c.IndexPageTemplate); err != nil {
+			reporter("index_page_template", "")("", "%v", err)
+		}
+	}
+	validatePackages("packages", c.Packages, reporter)
+
+	// Host names and aliases are matched without regard to case, so each must
+	// be unique in lowercase form.
+	owners := make(map[string]string)
+	claim := func(name, owner string, report reportFunc, field string) {
+		if err := checkHostName(name); err != nil {
+			report(field, "%v", err)
+			return
+		}
+		lower := strings.ToLower(name)
+		if prev, ok := owners[lower]; ok {
+			report(field, "host %q is already configured by %s", name, prev)
+			return
+		}
+		owners[lower] = owner
+	}
+	for _, name := range c.hostNames() {
+		h := c.Hosts[name]
+		key := toml.Key{"hosts", name}.String()
+		report := reporter(key, "")
+		claim(name, key, report, "")
+		for _, alias := range h.Aliases {
+			claim(alias, key, report, "aliases")
+		}
+		if h.DefaultRedirect != "" {
+			if err := checkDefaultRedirect(h.DefaultRedirect); err != nil {
+				report("default_redirect", "default_redirect: %v", err)
+			}
+		}
+		if err := checkRedirectStatus(h.RedirectStatus); err != nil {
+			report("redirect_status", "%v", err)
+		}
+		h.Cache.validate(report, "cache")
+		if h.IndexPageTemplate != "" {
+			if _, err := parseIndexTemplate(h.IndexPageTemplate); err != nil {
+				report("index_page_template", "index_page_template: %v", err)
+			}
+		}
+		validatePackages(h.key, h.Packages, reporter)
+	}
+	for i, pkgConf := range c.Packages {
+		host, _, _ := strings.Cut(pkgConf.Prefix, "/")
+		if owner, ok := owners[strings.ToLower(host)]; ok {
+			reporter(fmt.Sprintf("packages[%d]", i), pkgConf.Prefix)("prefix", "host %q is configured by %s, so this package is never used", host, owner)
+		}
+	}
+
+	if c.UnknownHost != nil && c.UnknownHost.Redirect != "" {
+		if err := checkDefaultRedirect(c.UnknownHost.Redirect); err != nil {
+			reporter("unknown_host", "")("redirect", "redirect: %v", err)
+		}
+	}
+
+	c.Server.validate(reporter)
+	if c.Proxy != nil {
+		c.Proxy.validate(reporter("proxy", ""))
+	}
+	checkSelfProxy := func(key string, p *packageConfig) {
+		for _, v := range p.selfProxied() {
+			switch {
+			case c.Proxy == nil:
+				reporter(key, p.Prefix)("import", "import \"mod self\" requires a [proxy] section")
+			case v.LocalRepo == "" && c.Proxy.Source == "":
+				reporter(key, p.Prefix)("import", "import \"mod self\" requires local_repo or proxy.source")
+			default:
+				continue
+			}
+			return
+		}
+	}
+	for i := range c.Packages {
+		checkSelfProxy(fmt.Sprintf("packages[%d]", i), &c.Packages[i])
+	}
+	for _, name := range c.hostNames() {
+		h := c.Hosts[name]
+		for i := range h.Packages {
+			checkSelfProxy(h.packageKey(i), &h.Packages[i])
+		}
+	}
+
+	return errs
+}
+
+// validatePackages checks a list of packages under the given key path, each of
+// which must have a unique prefix that some path can match.
+func validatePackages(key string, pkgs []packageConfig, reporter func(key, prefix string) reportFunc) {
+	var prefixes, explicit []string
+	for _, pkgConf := range pkgs {
+		if prefix := strings.TrimSuffix(pkgConf.Prefix, "/"); prefix != "" && !placeholderPattern.MatchString(prefix) {
+			explicit = append(explicit, prefix)
+		}
+	}
+
+	for i, pkgConf := range pkgs {
+		report := reporter(fmt.Sprintf("%s[%d]", key, i), pkgConf.Prefix)
+		pkgConf.validate(report)
+
+		// Prefixes that differ only in the names of their placeholders match
+		// exactly the same paths.
+		prefix := placeholderPattern.ReplaceAllString(strings.TrimSuffix(pkgConf.Prefix, "/"), "{}")
+		if prefix == "" {
+			continue
+		}
+		if slices.Contains(prefixes, prefix) {
+			report("prefix", "duplicate prefix")
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+
+		// An explicit prefix takes precedence over every pattern, so a pattern
+		// whose leading literal segments start with one can never match.
+		if placeholderPattern.MatchString(pkgConf.Prefix) {
+			for _, e := range explicit {
+				if strings.HasPrefix(prefix, e+"/") {
+					report("prefix", "pattern is unreachable, as explicit prefix %q takes precedence", e)
+					break
+				}
+			}
+		}
+	}
+}
+
+// checkDefaultRedirect validates a redirect URL for requests that don't match
+// any package, which can only use placeholders for parts of the request.
+func checkDefaultRedirect(url string) error {
+	vars := make(map[string]string)
+	for _, name := range requestVars {
+		vars[name] = "x"
+	}
+	if err := checkPlaceholders(url, vars); err != nil {
+		return err
+	}
+	return checkURL(expandPlaceholders(url, vars))
+}
+
+// validate checks a single package config for problems, independent of any
+// other packages.
+func (p *packageConfig) validate(report reportFunc) {
+	prefix := strings.TrimSuffix(p.Prefix, "/")
+	switch {
+	case prefix == "":
+		report("prefix", "missing prefix")
+	case strings.Contains(prefix, "://"):
+		report("prefix", "prefix must be an import path, not a URL")
+	}
+
+	p.validateShorthands(report)
+
+	// Placeholders in the prefix can be used in the other settings, and to
+	// validate those settings they're replaced with a sample value.
+	vars := make(map[string]string)
+	for i, segment := range splitPath(prefix) {
+		name, ok := placeholderName(segment)
+		switch {
+		case ok && i == 0:
+			report("prefix", "the first segment of the prefix must not be a placeholder")
+		case ok && (slices.Contains(requestVars, name) || slices.Contains(goSourceVars, name) || name == majorVar || vars[name] != ""):
+			report("prefix", "placeholder name %q is reserved or already used", name)
+		case ok:
+			vars[name] = "x"
+		case strings.ContainsAny(segment, "{}"):
+			report("prefix", "placeholders must make up an entire path segment")
+		}
+	}
+
+	p.validateStatus(report, vars)
+	if err := checkRedirectStatus(p.RedirectStatus); err != nil {
+		report("redirect_status", "%v", err)
+	}
+	p.Cache.validate(report, "cache")
+	if p.Status == statusRetired {
+		// Nothing but the status of a retired package is served, so it only
+		// needs its prefix.
+		return
+	}
+
+	p.validateImport(report, vars)
+	p.validateSelector(report)
+	p.validateSource(report, vars)
+	p.validateVersions(report, vars)
+
+	if p.Redirect == "" {
+		report("redirect", "missing redirect")
+	} else {
+		p.validateRedirect(report, vars)
+	}
+}
+
+// validateImport checks the import and subdir settings of a package, with the
+// placeholders from its prefix set in vars.
+func (p *packageConfig) validateImport(report reportFunc, vars map[string]string) {
+	if len(p.Import) == 0 {
+		report("import", "missing import")
+	}
+	vcsCount := make(map[bool]int) // keyed by whether the VCS is "mod"
+	for _, imp := range p.Import {
+		if err := checkPlaceholders(imp, vars); err != nil {
+			report("import", "import: %v", err)
+		} else if err := checkImport(expandPlaceholders(imp, vars)); err != nil {
+			report("import", "%v", err)
+		}
+		vcsCount[importVCS(imp) == "mod"]++
+	}
+	if vcsCount[true] > 1 || vcsCount[false] > 1 {
+		report("import", "import must have at most one \"mod\" entry and one entry for another VCS")
+	}
+
+	if p.Subdir != "" {
+		if err := checkPlaceholders(p.Subdir, vars); err != nil {
+			report("subdir", "subdir: %v", err)
+		} else if err := checkSubdir(expandPlaceholders(p.Subdir, vars)); err != nil {
+			report("subdir", "%v", err)
+		}
+		if _, _, ok := p.Import.repoRoot(); !ok && p.LocalRepo == "" {
+			report("subdir", "subdir is not supported with the mod VCS")
+		}
+	}
+
+	if p.LocalRepo != "" {
+		if err := checkPlaceholders(p.LocalRepo, vars); err != nil {
+			report("local_repo", "local_repo: %v", err)
+		} else if !filepath.IsAbs(p.LocalRepo) {
+			report("local_repo", "local_repo must be an absolute path")
+		}
+		if p.Import.moduleProxy() != selfProxy {
+			report("local_repo", "local_repo requires import \"mod self\"")
+		}
+	}
+}
+
+// validateRedirect checks the redirect setting of a package, which can use the
+// placeholders from its prefix set in vars along with those from the request.
+func (p *packageConfig) validateRedirect(report reportFunc, vars map[string]string) {
+	vars = maps.Clone(vars)
+	for _, name := range requestVars {
+		vars[name] = "x"
+	}
+	vars[majorVar] = "x"
+	if err := checkPlaceholders(p.Redirect, vars); err != nil {
+		report("redirect", "redirect: %v", err)
+	} else if err := checkURL(expandPlaceholders(p.Redirect, vars)); err != nil {
+		report("redirect", "redirect: %v", err)
+	}
+}
+
+// checkPlaceholders validates that every placeholder in s has a value in vars.
+func checkPlaceholders(s string, vars map[string]string) error {
+	for _, name := range placeholderNames(s) {
+		if _, ok := vars[name]; !ok {
+			return fmt.Errorf("unknown placeholder %q", "{"+name+"}")
+		}
+	}
+	return nil
+}
+
+// checkImport validates the "vcs repo-root" content of a go-import tag.
+func checkImport(imp string) error {
+	fields := strings.Fields(imp)
+	if len(fields) != 2 {
+		return fmt.Errorf("import %q must have the form \"<vcs> <repo-root>\"", imp)
+	}
+
+	vcs, root := fields[0], fields[1]
+	if !slices.Contains(knownVCS, vcs) {
+		return fmt.Errorf("import %q has unknown VCS %q (want one of %s)", imp, vcs, strings.Join(knownVCS, ", "))
+	}
+	if vcs == "mod" && root == selfProxy {
+		return nil
+	}
+	if err := checkURL(root); err != nil {
+		return fmt.Errorf("import %q has invalid repo root: %v", imp, err)
+	}
+	return nil
+}
+
+// checkSubdir validates the subdirectory field of a go-import tag, which names
+// the directory of a module within its repository.
+func checkSubdir(subdir string) error {
+	if path.IsAbs(subdir) || path.Clean(subdir) != subdir || subdir == "." ||
+		subdir == ".." || strings.HasPrefix(subdir, "../") || strings.ContainsAny(subdir, " \t\\") {
+		return fmt.Errorf("subdir %q must be a clean, relative, slash-separated path within the repository", subdir)
+	}
+	return nil
+}
+
+// checkURL validates that s is an absolute URL.
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", s)
+	}
+	return nil
+}
+
+// undecodedKeys returns the keys in the TOML data that did not correspond to
+// any config field, with the index of each array table entry included in the
+// key (for example, "packages[2].prfix").
+func undecodedKeys(md toml.MetaData) []string {
+	undecoded := make(map[string]bool)
+	for _, key := range md.Undecoded() {
+		undecoded[key.String()] = true
+	}
+	if len(undecoded) == 0 {
+		return nil
+	}
+
+	var (
+		keys    []string
+		indexes = make(map[string]int)
+	)
+	for _, key := range md.Keys() {
+		if md.Type(key...) == "ArrayHash" {
+			indexes[key.String()]++
+		}
+		if !undecoded[key.String()] {
+			continue
+		}
+
+		var indexed strings.Builder
+		for i := range key {
+			if i > 0 {
+				indexed.WriteByte('.')
+			}
+			indexed.WriteString(key[i : i+1].String())
+			if n, ok := indexes[key[:i+1].String()]; ok && i < len(key)-1 {
+				fmt.Fprintf(&indexed, "[%d]", n-1)
+			}
+		}
+		keys = append(keys, indexed.String())
+	}
+	return keys
+}
@@ -0,0 +1,112 @@
+package bouncer
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// versionConfig holds the settings for one major version of a package, like
+// "example.com/lib/v2", that differ from the settings of the package itself.
+// Any setting that isn't set is the same as for the package.
+type versionConfig struct {
+	Import    importList `toml:"import"`
+	Subdir    string     `toml:"subdir"`
+	LocalRepo string     `toml:"local_repo"`
+	Branch    string     `toml:"branch"`
+	Redirect  string     `toml:"redirect"`
+}
+
+// majorVar is the name of the placeholder for the major version suffix of the
+// requested import path, like "v2", or the major version that the path selects
+// with a version selector, or "" for a path with neither.
+const majorVar = "major"
+
+// majorVersion returns the major version suffix, like "v2", that a segment of
+// an import path forms under Go's module path rules, if it forms one.
+func majorVersion(segment string) (major string, ok bool) {
+	_, pathMajor, ok := module.SplitPathVersion("example.com/" + segment)
+	if !ok || pathMajor == "" {
+		return "", false
+	}
+	return strings.TrimPrefix(pathMajor, "/"), true
+}
+
+// forVersion returns a copy of the package config with the settings for the
+// major version applied, which must be one of the package's versions. The
+// successor of a moved or deprecated package keeps the same major version.
+func (p packageConfig) forVersion(major string) packageConfig {
+	v := p.Versions[major]
+	if len(v.Import) > 0 {
+		p.Import = v.Import
+	}
+	if v.Subdir != "" {
+		p.Subdir = v.Subdir
+	}
+	if v.LocalRepo != "" {
+		p.LocalRepo = v.LocalRepo
+	}
+	if v.Branch != "" {
+		p.Source.Branch = v.Branch
+	}
+	if v.Redirect != "" {
+		p.Redirect = v.Redirect
+	}
+	p.Successor = p.versionSuccessor(major)
+	p.Versions = nil
+	return p
+}
+
+// validateVersions checks the settings for each major version of the package,
+// as they apply on top of the settings for the package itself.
+func (p *packageConfig) validateVersions(report reportFunc, vars map[string]string) {
+	for _, major := range p.majors() {
+		field := "versions." + major
+		versionReport := func(subfield, format string, args ...any) {
+			if subfield != "" {
+				subfield = field + "." + subfield
+			} else {
+				subfield = field
+			}
+			report(subfield, field+": "+format, args...)
+		}
+
+		if m, ok := majorVersion(major); !ok || m != major {
+			versionReport("", "%q is not a major version suffix like \"v2\"", major)
+			continue
+		}
+		v := p.forVersion(major)
+		if settings := p.Versions[major]; len(settings.Import) > 0 || settings.Subdir != "" || settings.LocalRepo != "" {
+			v.validateImport(versionReport, vars)
+		}
+		if p.Versions[major].Redirect != "" {
+			v.validateRedirect(versionReport, vars)
+		}
+	}
+}
+
+// majors returns the major versions that the package has settings for, in
+// sorted order.
+func (p *packageConfig) majors() []string {
+	majors := make([]string, 0, len(p.Versions))
+	for major := range p.Versions {
+		majors = append(majors, major)
+	}
+	slices.Sort(majors)
+	return majors
+}
+
+// versionedPrefix returns the number of segments of pathSegments that the
+// package's prefix covers, which is one more than prefixLen when the next
+// segment is one of the package's major versions, along with that version.
+func (p *packageConfig) versionedPrefix(pathSegments []string, prefixLen int) (n int, major string) {
+	if len(p.Versions) == 0 || prefixLen >= len(pathSegments) {
+		return prefixLen, ""
+	}
+	major, ok := majorVersion(pathSegments[prefixLen])
+	if _, configured := p.Versions[major]; !ok || !configured {
+		return prefixLen, ""
+	}
+	return prefixLen + 1, major
+}